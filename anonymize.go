@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"docker-autocompose/autocompose"
+)
+
+// anonymize strips what identifies the host from the compose file while
+// keeping its shape: environment values are redacted, bind sources and local
+// volume devices become /path/to/dataN placeholders (the same path getting
+// the same placeholder everywhere), the addresses in extra_hosts, dns,
+// dns_search and network volume options become documentation addresses and
+// hostN.example names, and labels, hostnames and static IPs are dropped.
+func anonymize(compose *autocompose.ComposeFile) {
+	placeholders := make(map[string]string)
+	placeholder := func(source string) string {
+		if !path.IsAbs(source) {
+			return source
+		}
+		source = path.Clean(source)
+		if _, ok := placeholders[source]; !ok {
+			placeholders[source] = fmt.Sprintf("/path/to/data%d", len(placeholders)+1)
+		}
+		return placeholders[source]
+	}
+	addresses := make(map[string]string)
+	var ipv4s, ipv6s, hosts int
+	address := func(value string) string {
+		ip := net.ParseIP(value)
+		if value == "" || value == "host-gateway" || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified())) {
+			return value
+		}
+		if _, ok := addresses[value]; !ok {
+			switch {
+			case ip == nil:
+				hosts++
+				addresses[value] = fmt.Sprintf("host%d.example", hosts)
+			case ip.To4() != nil:
+				ipv4s++
+				addresses[value] = fmt.Sprintf("192.0.2.%d", ipv4s)
+			default:
+				ipv6s++
+				addresses[value] = fmt.Sprintf("2001:db8::%x", ipv6s)
+			}
+		}
+		return addresses[value]
+	}
+
+	// visit services in order so that the numbering is stable
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service := compose.Services[name]
+		for key := range service.Environment {
+			service.Environment[key] = "<redacted>"
+		}
+		for i, volume := range service.Volumes {
+			if volume.Long != nil {
+				if volume.Long.Type == "bind" {
+					volume.Long.Source = placeholder(volume.Long.Source)
+				}
+			} else if source, rest, ok := strings.Cut(volume.Short, ":"); ok {
+				volume.Short = placeholder(source) + ":" + rest
+			}
+			service.Volumes[i] = volume
+		}
+		for networkName, network := range service.Networks {
+			if network != nil {
+				network.Ipv4Address, network.Ipv6Address = "", ""
+				if len(network.Aliases) == 0 {
+					service.Networks[networkName] = nil
+				}
+			}
+		}
+		for i, entry := range service.ExtraHosts {
+			// host:ip, or host=ip, the host-gateway one naming nothing private
+			if j := strings.IndexAny(entry, ":="); j > 0 && entry[j+1:] != "host-gateway" {
+				service.ExtraHosts[i] = address(entry[:j]) + entry[j:j+1] + address(entry[j+1:])
+			}
+		}
+		for i, server := range service.Dns {
+			service.Dns[i] = address(server)
+		}
+		for i, domain := range service.DnsSearch {
+			service.DnsSearch[i] = address(domain)
+		}
+		service.Labels = nil
+		service.Hostname = ""
+		service.Domainname = ""
+		compose.Services[name] = service
+	}
+
+	volumeNames := make([]string, 0, len(compose.Volumes))
+	for name := range compose.Volumes {
+		volumeNames = append(volumeNames, name)
+	}
+	sort.Strings(volumeNames)
+	for _, name := range volumeNames {
+		volume := compose.Volumes[name]
+		if device, ok := volume.DriverOpts["device"]; ok {
+			// A path, or server:/export for NFS
+			if server, export, ok := strings.Cut(device, ":"); ok && path.IsAbs(export) {
+				volume.DriverOpts["device"] = address(server) + ":" + placeholder(export)
+			} else {
+				volume.DriverOpts["device"] = placeholder(device)
+			}
+		}
+		if options, ok := volume.DriverOpts["o"]; ok {
+			volume.DriverOpts["o"] = anonymizeMountOptions(options, address)
+		}
+		volume.Labels = nil
+		compose.Volumes[name] = volume
+	}
+}
+
+// anonymizeMountOptions replaces the server address of network mount options
+// such as "addr=10.0.0.5,nfsvers=4" and redacts the credentials of CIFS ones.
+func anonymizeMountOptions(options string, address func(string) string) string {
+	fields := strings.Split(options, ",")
+	for i, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "addr":
+			fields[i] = key + "=" + address(value)
+		case "username", "user", "password", "pass", "domain":
+			fields[i] = key + "=<redacted>"
+		}
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"docker-autocompose/autocompose"
+)
+
+func TestAnonymize(t *testing.T) {
+	compose := autocompose.ComposeFile{
+		Services: map[string]autocompose.ComposeService{
+			"web": {
+				Image:      "nginx",
+				ExtraHosts: []string{"db.internal:10.0.0.5", "host.docker.internal:host-gateway", "v6.internal:fd00::5", "nas=10.0.0.9"},
+				Dns:        []string{"10.0.0.2", "127.0.0.11"},
+				DnsSearch:  []string{"corp.internal"},
+				Volumes:    []autocompose.ComposeServiceVolume{{Short: "/srv/www:/usr/share/nginx/html"}},
+			},
+			"worker": {
+				Image:      "worker",
+				ExtraHosts: []string{"db.internal:10.0.0.5"},
+			},
+		},
+		Volumes: map[string]autocompose.ComposeVolume{
+			"nfs":  {Driver: "local", DriverOpts: map[string]string{"type": "nfs", "o": "addr=10.0.0.9,rw,nfsvers=4", "device": ":/export/media"}},
+			"cifs": {Driver: "local", DriverOpts: map[string]string{"type": "cifs", "o": "addr=nas.corp.internal,username=alice,password=hunter2", "device": "//nas.corp.internal/share"}},
+			"bind": {Driver: "local", DriverOpts: map[string]string{"type": "none", "o": "bind", "device": "/srv/www"}},
+			"host": {Driver: "local", DriverOpts: map[string]string{"type": "nfs", "device": "nas.corp.internal:/export/backup"}},
+		},
+	}
+	anonymize(&compose)
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"web extra_hosts", compose.Services["web"].ExtraHosts, []string{"host1.example:192.0.2.1", "host.docker.internal:host-gateway", "host2.example:2001:db8::1", "host3.example=192.0.2.2"}},
+		{"worker extra_hosts", compose.Services["worker"].ExtraHosts, []string{"host1.example:192.0.2.1"}},
+		{"dns", compose.Services["web"].Dns, []string{"192.0.2.3", "127.0.0.11"}},
+		{"dns_search", compose.Services["web"].DnsSearch, []string{"host4.example"}},
+		{"bind", []string{compose.Services["web"].Volumes[0].Short}, []string{"/path/to/data1:/usr/share/nginx/html"}},
+		{"nfs options", []string{compose.Volumes["nfs"].DriverOpts["o"], compose.Volumes["nfs"].DriverOpts["device"]}, []string{"addr=192.0.2.2,rw,nfsvers=4", ":/path/to/data4"}},
+		{"cifs options", []string{compose.Volumes["cifs"].DriverOpts["o"], compose.Volumes["cifs"].DriverOpts["device"]}, []string{"addr=host5.example,username=<redacted>,password=<redacted>", "/path/to/data2"}},
+		{"local device", []string{compose.Volumes["bind"].DriverOpts["device"]}, []string{"/path/to/data1"}},
+		{"nfs device with server", []string{compose.Volumes["host"].DriverOpts["device"]}, []string{"host5.example:/path/to/data3"}},
+	}
+	for _, tt := range tests {
+		if !slices.Equal(tt.got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
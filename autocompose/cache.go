@@ -0,0 +1,85 @@
+package autocompose
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// cacheEntry is the result of one call, fetched once however many goroutines
+// ask for it.
+type cacheEntry[T any] struct {
+	once  sync.Once
+	value T
+	err   error
+}
+
+// cache remembers the results of calls by key, errors included.
+type cache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry[T]
+}
+
+func (c *cache[T]) get(key string, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry[T])
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry[T]{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.value, entry.err = fetch()
+	})
+	return entry.value, entry.err
+}
+
+// cachingClient answers repeated inspects of the images, volumes and networks
+// containers share, and the daemon info, from the first response. It is safe
+// for concurrent use.
+type cachingClient struct {
+	Client
+	images   cache[image.InspectResponse]
+	volumes  cache[volume.Volume]
+	networks cache[network.Inspect]
+	info     cache[system.Info]
+}
+
+func (c *cachingClient) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	if len(inspectOpts) > 0 {
+		return c.Client.ImageInspect(ctx, imageID, inspectOpts...)
+	}
+	return c.images.get(imageID, func() (image.InspectResponse, error) {
+		return c.Client.ImageInspect(ctx, imageID)
+	})
+}
+
+func (c *cachingClient) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	return c.volumes.get(volumeID, func() (volume.Volume, error) {
+		return c.Client.VolumeInspect(ctx, volumeID)
+	})
+}
+
+func (c *cachingClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	if options != (network.InspectOptions{}) {
+		return c.Client.NetworkInspect(ctx, networkID, options)
+	}
+	return c.networks.get(networkID, func() (network.Inspect, error) {
+		return c.Client.NetworkInspect(ctx, networkID, options)
+	})
+}
+
+func (c *cachingClient) Info(ctx context.Context) (system.Info, error) {
+	return c.info.get("", func() (system.Info, error) {
+		return c.Client.Info(ctx)
+	})
+}
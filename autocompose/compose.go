@@ -0,0 +1,917 @@
+package autocompose
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeService fields are emitted in declaration order, the most commonly
+// read keys first. The environment, labels, command and healthcheck test hold
+// the values as written to the file, a literal $ doubled.
+type ComposeService struct {
+	Image           string                   `yaml:"image,omitempty"`
+	Build           *ComposeBuild            `yaml:"build,omitempty"`
+	ContainerName   string                   `yaml:"container_name,omitempty"`
+	Profiles        []string                 `yaml:"profiles,omitempty"`
+	Ports           []ComposeServicePort     `yaml:"ports,omitempty"`
+	Volumes         []ComposeServiceVolume   `yaml:"volumes,omitempty"`
+	VolumesFrom     []string                 `yaml:"volumes_from,omitempty"`
+	Environment     StringMap                `yaml:"environment,omitempty"`
+	EnvFile         []string                 `yaml:"env_file,omitempty"`
+	Expose          []string                 `yaml:"expose,omitempty"`
+	Restart         string                   `yaml:"restart,omitempty"`
+	PullPolicy      string                   `yaml:"pull_policy,omitempty"`
+	Cpus            string                   `yaml:"cpus,omitempty"`
+	Cpuset          string                   `yaml:"cpuset,omitempty"`
+	MemLimit        string                   `yaml:"mem_limit,omitempty"`
+	MemReservation  string                   `yaml:"mem_reservation,omitempty"`
+	MemswapLimit    interface{}              `yaml:"memswap_limit,omitempty"`
+	OomKillDisable  bool                     `yaml:"oom_kill_disable,omitempty"`
+	OomScoreAdj     int                      `yaml:"oom_score_adj,omitempty"`
+	BlkioConfig     *ComposeBlkioConfig      `yaml:"blkio_config,omitempty"`
+	PidsLimit       *int64                   `yaml:"pids_limit,omitempty"`
+	Init            *bool                    `yaml:"init,omitempty"`
+	Networks        ComposeServiceNetworks   `yaml:"networks,omitempty"`
+	Links           []string                 `yaml:"links,omitempty"`
+	ExternalLinks   []string                 `yaml:"external_links,omitempty"`
+	DependsOn       ComposeDependsOn         `yaml:"depends_on,omitempty"`
+	CapAdd          []string                 `yaml:"cap_add,omitempty"`
+	CapDrop         []string                 `yaml:"cap_drop,omitempty"`
+	Privileged      bool                     `yaml:"privileged,omitempty"`
+	Healthcheck     *ComposeHealthcheck      `yaml:"healthcheck,omitempty"`
+	Tty             bool                     `yaml:"tty,omitempty"`
+	User            string                   `yaml:"user,omitempty"`
+	Cmd             ComposeCommand           `yaml:"command,omitempty"`
+	Entrypoint      ComposeCommand           `yaml:"entrypoint,omitempty"`
+	Labels          StringMap                `yaml:"labels,omitempty"`
+	Hostname        string                   `yaml:"hostname,omitempty"`
+	Domainname      string                   `yaml:"domainname,omitempty"`
+	StdinOpen       bool                     `yaml:"stdin_open,omitempty"`
+	StdinOnce       bool                     `yaml:"stdin_once,omitempty"`
+	WorkingDir      string                   `yaml:"working_dir,omitempty"`
+	NetworkMode     string                   `yaml:"network_mode,omitempty"`
+	Ipc             string                   `yaml:"ipc,omitempty"`
+	Pid             string                   `yaml:"pid,omitempty"`
+	StopSignal      string                   `yaml:"stop_signal,omitempty"`
+	StopGracePeriod string                   `yaml:"stop_grace_period,omitempty"`
+	Shell           []string                 `yaml:"shell,omitempty"`
+	Dns             []string                 `yaml:"dns,omitempty"`
+	DnsSearch       []string                 `yaml:"dns_search,omitempty"`
+	DnsOptions      []string                 `yaml:"dns_opt,omitempty"`
+	Devices         []string                 `yaml:"devices,omitempty"`
+	ExtraHosts      []string                 `yaml:"extra_hosts,omitempty"`
+	Ulimits         map[string]ComposeUlimit `yaml:"ulimits,omitempty"`
+	Tmpfs           []string                 `yaml:"tmpfs,omitempty"`
+	Secrets         []ComposeFileReference   `yaml:"secrets,omitempty"`
+	Configs         []ComposeFileReference   `yaml:"configs,omitempty"`
+	GroupAdd        []string                 `yaml:"group_add,omitempty"`
+	Runtime         string                   `yaml:"runtime,omitempty"`
+	Cgroup          string                   `yaml:"cgroup,omitempty"`
+	Platform        string                   `yaml:"platform,omitempty"`
+	Deploy          *ComposeDeploy           `yaml:"deploy,omitempty"`
+
+	// The container the service was generated from and its references to
+	// other containers, resolved by linkServices once all are exported
+	containerID   string
+	containerName string
+	volumesFrom   []*volumesFromRef
+	links         []linkRef
+	dependsOn     []dependencyRef
+	namespaces    []namespaceRef
+	composeName   string // the com.docker.compose.service label
+	buildStub     bool   // emit a commented out build section to fill in
+	imageID       string
+	imageDigest   string
+	created       string
+	notes         []serviceNote // explanations emitted with --annotate
+}
+
+// serviceNote explains how the value of a key was derived, or why it was left
+// out of the service.
+type serviceNote struct {
+	key  string
+	text string
+}
+
+// note records an explanation for key, shown as a comment with --annotate.
+func (s *ComposeService) note(key, format string, args ...interface{}) {
+	s.notes = append(s.notes, serviceNote{key: key, text: fmt.Sprintf(format, args...)})
+}
+
+// Notes returns the explanations recorded for derived and omitted values, as
+// "key: text".
+func (s ComposeService) Notes() []string {
+	notes := make([]string, len(s.notes))
+	for i, note := range s.notes {
+		notes[i] = note.key + ": " + note.text
+	}
+	return notes
+}
+
+// linkRef is a legacy --link to another container under an alias.
+type linkRef struct {
+	containerName string
+	alias         string
+}
+
+// namespaceRef is a network, IPC or PID namespace shared with another
+// container.
+type namespaceRef struct {
+	key         string // network_mode, ipc or pid
+	containerID string // empty when the container could not be inspected
+	container   string
+}
+
+// setNamespace sets the network_mode, ipc or pid key of the service.
+func (s *ComposeService) setNamespace(key, value string) {
+	switch key {
+	case "network_mode":
+		s.NetworkMode = value
+	case "ipc":
+		s.Ipc = value
+	case "pid":
+		s.Pid = value
+	}
+}
+
+// dependencyRef is a compose service the container's project declared it
+// depends on, with the condition it waits for.
+type dependencyRef struct {
+	service   string
+	condition string
+	restart   bool
+}
+
+// volumesFromRef is a --volumes-from reference, with the volume entries the
+// container inherited through it.
+type volumesFromRef struct {
+	containerID   string
+	containerName string
+	readOnly      bool
+	volumes       []ComposeServiceVolume
+}
+
+// StringMap is a string map whose values always read back as strings: values
+// YAML would re-type as booleans, numbers or null are emitted double-quoted.
+type StringMap map[string]string
+
+func (m StringMap) MarshalYAML() (interface{}, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		node.Content = append(node.Content, stringNode(key), stringNode(m[key]))
+	}
+	return node, nil
+}
+
+func stringNode(value string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	if needsQuoting(value) {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
+
+// needsQuoting reports whether a plain scalar would not read back as the same
+// string, including YAML 1.1 booleans like yes/no/on/off that some parsers honour.
+func needsQuoting(value string) bool {
+	if value == "" || strings.TrimSpace(value) != value {
+		return true
+	}
+	switch strings.ToLower(value) {
+	case "y", "n", "yes", "no", "on", "off", "true", "false", "null", "~":
+		return true
+	}
+	var decoded interface{}
+	if err := yaml.Unmarshal([]byte(value), &decoded); err != nil {
+		return true
+	}
+	str, isString := decoded.(string)
+	return !isString || str != value
+}
+
+type ComposeHealthcheck struct {
+	Disable       bool          `yaml:"disable,omitempty"`
+	Test          []string      `yaml:"test,omitempty"`
+	Interval      time.Duration `yaml:"interval,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	Retries       int           `yaml:"retries,omitempty"`
+	StartPeriod   time.Duration `yaml:"start_period,omitempty"`
+	StartInterval time.Duration `yaml:"start_interval,omitempty"`
+}
+
+// MarshalYAML renders the healthcheck durations in the "1m30s" notation compose
+// expects instead of raw nanosecond integers, omitting zero values. Shell tests
+// are emitted in the plain string form.
+func (h ComposeHealthcheck) MarshalYAML() (interface{}, error) {
+	if h.Disable {
+		return struct {
+			Disable bool `yaml:"disable"`
+		}{true}, nil
+	}
+	var test interface{}
+	if len(h.Test) == 2 && h.Test[0] == "CMD-SHELL" {
+		test = h.Test[1]
+	} else if len(h.Test) > 0 {
+		test = h.Test
+	}
+	return struct {
+		Test          interface{} `yaml:"test,omitempty"`
+		Interval      string      `yaml:"interval,omitempty"`
+		Timeout       string      `yaml:"timeout,omitempty"`
+		Retries       int         `yaml:"retries,omitempty"`
+		StartPeriod   string      `yaml:"start_period,omitempty"`
+		StartInterval string      `yaml:"start_interval,omitempty"`
+	}{
+		Test:          test,
+		Interval:      formatDuration(h.Interval),
+		Timeout:       formatDuration(h.Timeout),
+		Retries:       h.Retries,
+		StartPeriod:   formatDuration(h.StartPeriod),
+		StartInterval: formatDuration(h.StartInterval),
+	}, nil
+}
+
+type ComposeUlimit struct {
+	Soft int64 `yaml:"soft"`
+	Hard int64 `yaml:"hard"`
+}
+
+// MarshalYAML collapses the ulimit to a single integer when soft and hard match.
+func (u ComposeUlimit) MarshalYAML() (interface{}, error) {
+	if u.Soft == u.Hard {
+		return u.Soft, nil
+	}
+	return struct {
+		Soft int64 `yaml:"soft"`
+		Hard int64 `yaml:"hard"`
+	}{u.Soft, u.Hard}, nil
+}
+
+type ComposeBuild struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile,omitempty"`
+}
+
+// ComposeCommand is a command or entrypoint. An empty but non-nil one clears
+// the image default and is emitted as [], nil is left out.
+type ComposeCommand []string
+
+func (c ComposeCommand) IsZero() bool {
+	return c == nil
+}
+
+// ComposeServicePort is a service ports entry, marshalled as the short
+// "host:container/proto" string unless the long form is set.
+type ComposeServicePort struct {
+	Short string
+	Long  *ComposePortConfig
+}
+
+func (p ComposeServicePort) MarshalYAML() (interface{}, error) {
+	if p.Long != nil {
+		return p.Long, nil
+	}
+	return p.Short, nil
+}
+
+type ComposePortConfig struct {
+	Target    uint32 `yaml:"target"`
+	Published string `yaml:"published,omitempty"`
+	Protocol  string `yaml:"protocol,omitempty"`
+	Mode      string `yaml:"mode,omitempty"`
+}
+
+// ComposeServiceVolume is a service volumes entry, marshalled as the short
+// "source:target:ro" string unless the long form is set.
+type ComposeServiceVolume struct {
+	Short string
+	Long  *ComposeVolumeMount
+}
+
+func (v ComposeServiceVolume) MarshalYAML() (interface{}, error) {
+	if v.Long != nil {
+		return v.Long, nil
+	}
+	return v.Short, nil
+}
+
+// Target returns the path the entry is mounted at in the container.
+func (v ComposeServiceVolume) Target() string {
+	if v.Long != nil {
+		return v.Long.Target
+	}
+	parts := strings.Split(v.Short, ":")
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return parts[1]
+}
+
+type ComposeVolumeMount struct {
+	Type     string                `yaml:"type"`
+	Source   string                `yaml:"source,omitempty"`
+	Target   string                `yaml:"target"`
+	ReadOnly bool                  `yaml:"read_only,omitempty"`
+	Bind     *ComposeBindOptions   `yaml:"bind,omitempty"`
+	Volume   *ComposeVolumeOptions `yaml:"volume,omitempty"`
+	Tmpfs    *ComposeTmpfsOptions  `yaml:"tmpfs,omitempty"`
+}
+
+type ComposeBindOptions struct {
+	Propagation string `yaml:"propagation,omitempty"`
+}
+
+type ComposeVolumeOptions struct {
+	NoCopy  bool   `yaml:"nocopy,omitempty"`
+	Subpath string `yaml:"subpath,omitempty"`
+}
+
+type ComposeTmpfsOptions struct {
+	Size string `yaml:"size,omitempty"`
+	Mode string `yaml:"mode,omitempty"`
+}
+
+// ComposeServiceNetworks marshals as a plain list of network names unless a
+// network carries per-service settings, in which case it becomes a map.
+type ComposeServiceNetworks map[string]*ComposeServiceNetwork
+
+func (n ComposeServiceNetworks) MarshalYAML() (interface{}, error) {
+	names := make([]string, 0, len(n))
+	detailed := false
+	for name, network := range n {
+		names = append(names, name)
+		detailed = detailed || network != nil
+	}
+	sort.Strings(names)
+	if !detailed {
+		return names, nil
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range names {
+		network := n[name]
+		if network == nil {
+			network = &ComposeServiceNetwork{}
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(network); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, stringNode(name), value)
+	}
+	return node, nil
+}
+
+// ComposeDependsOn maps the services a service depends on to the condition it
+// waits for. It marshals as a plain list of service names when the services
+// only need to have started, and as a map otherwise.
+type ComposeDependsOn map[string]ComposeDependency
+
+func (d ComposeDependsOn) MarshalYAML() (interface{}, error) {
+	names := make([]string, 0, len(d))
+	detailed := false
+	for name, dependency := range d {
+		names = append(names, name)
+		detailed = detailed || dependency != (ComposeDependency{Condition: "service_started"})
+	}
+	sort.Strings(names)
+	if !detailed {
+		return names, nil
+	}
+	return map[string]ComposeDependency(d), nil
+}
+
+type ComposeDependency struct {
+	Condition string `yaml:"condition"`
+	Restart   bool   `yaml:"restart,omitempty"`
+}
+
+type ComposeServiceNetwork struct {
+	Ipv4Address string   `yaml:"ipv4_address,omitempty"`
+	Ipv6Address string   `yaml:"ipv6_address,omitempty"`
+	Aliases     []string `yaml:"aliases,omitempty"`
+}
+
+type ComposeBlkioConfig struct {
+	Weight          uint16                  `yaml:"weight,omitempty"`
+	WeightDevice    []ComposeWeightDevice   `yaml:"weight_device,omitempty"`
+	DeviceReadBps   []ComposeThrottleDevice `yaml:"device_read_bps,omitempty"`
+	DeviceWriteBps  []ComposeThrottleDevice `yaml:"device_write_bps,omitempty"`
+	DeviceReadIops  []ComposeThrottleDevice `yaml:"device_read_iops,omitempty"`
+	DeviceWriteIops []ComposeThrottleDevice `yaml:"device_write_iops,omitempty"`
+}
+
+type ComposeWeightDevice struct {
+	Path   string `yaml:"path"`
+	Weight uint16 `yaml:"weight"`
+}
+
+// ComposeThrottleDevice holds a byte rate as a string ("10mb") or an IO
+// operation rate as an integer.
+type ComposeThrottleDevice struct {
+	Path string      `yaml:"path"`
+	Rate interface{} `yaml:"rate"`
+}
+
+type ComposeDeploy struct {
+	Mode          string                `yaml:"mode,omitempty"`
+	Replicas      *uint64               `yaml:"replicas,omitempty"`
+	EndpointMode  string                `yaml:"endpoint_mode,omitempty"`
+	Labels        StringMap             `yaml:"labels,omitempty"`
+	Resources     ComposeResources      `yaml:"resources,omitempty"`
+	RestartPolicy *ComposeRestartPolicy `yaml:"restart_policy,omitempty"`
+	Placement     *ComposePlacement     `yaml:"placement,omitempty"`
+}
+
+type ComposeResources struct {
+	Limits       *ComposeLimits       `yaml:"limits,omitempty"`
+	Reservations *ComposeReservations `yaml:"reservations,omitempty"`
+}
+
+type ComposeLimits struct {
+	Cpus   string `yaml:"cpus,omitempty"`
+	Memory string `yaml:"memory,omitempty"`
+	Pids   int64  `yaml:"pids,omitempty"`
+}
+
+type ComposeReservations struct {
+	Cpus    string                 `yaml:"cpus,omitempty"`
+	Memory  string                 `yaml:"memory,omitempty"`
+	Devices []ComposeDeviceRequest `yaml:"devices,omitempty"`
+}
+
+type ComposeRestartPolicy struct {
+	Condition   string  `yaml:"condition,omitempty"`
+	Delay       string  `yaml:"delay,omitempty"`
+	MaxAttempts *uint64 `yaml:"max_attempts,omitempty"`
+	Window      string  `yaml:"window,omitempty"`
+}
+
+type ComposePlacement struct {
+	Constraints        []string                     `yaml:"constraints,omitempty"`
+	Preferences        []ComposePlacementPreference `yaml:"preferences,omitempty"`
+	MaxReplicasPerNode uint64                       `yaml:"max_replicas_per_node,omitempty"`
+}
+
+type ComposePlacementPreference struct {
+	Spread string `yaml:"spread"`
+}
+
+type ComposeDeviceRequest struct {
+	Driver       string            `yaml:"driver,omitempty"`
+	Count        interface{}       `yaml:"count,omitempty"`
+	DeviceIDs    []string          `yaml:"device_ids,omitempty"`
+	Capabilities []string          `yaml:"capabilities,omitempty"`
+	Options      map[string]string `yaml:"options,omitempty"`
+}
+
+type ComposeVolume struct {
+	External   bool              `yaml:"external,omitempty"`
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+	Labels     StringMap         `yaml:"labels,omitempty"`
+}
+
+type ComposeNetwork struct {
+	External bool   `yaml:"external,omitempty"`
+	Name     string `yaml:"name,omitempty"`
+}
+
+// ComposeFileReference is a service secrets or configs entry, marshalled as
+// the bare source name when it is mounted at the default target.
+type ComposeFileReference struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target,omitempty"`
+	Mode   string `yaml:"mode,omitempty"`
+}
+
+func (r ComposeFileReference) MarshalYAML() (interface{}, error) {
+	if r.Target == "" && r.Mode == "" {
+		return r.Source, nil
+	}
+	type plain ComposeFileReference
+	return plain(r), nil
+}
+
+type ComposeSecret struct {
+	External bool   `yaml:"external,omitempty"`
+	Name     string `yaml:"name,omitempty"`
+}
+
+type ComposeConfig struct {
+	External bool   `yaml:"external,omitempty"`
+	Name     string `yaml:"name,omitempty"`
+}
+
+type ComposeFile struct {
+	Version  string                    `yaml:"version,omitempty"`
+	Name     string                    `yaml:"name,omitempty"`
+	Services map[string]ComposeService `yaml:"services"`
+	Volumes  map[string]ComposeVolume  `yaml:"volumes,omitempty"`
+	Networks map[string]ComposeNetwork `yaml:"networks,omitempty"`
+	Secrets  map[string]ComposeSecret  `yaml:"secrets,omitempty"`
+	Configs  map[string]ComposeConfig  `yaml:"configs,omitempty"`
+
+	daemonHostname string // host the containers were exported from
+}
+
+func newComposeFile() ComposeFile {
+	return ComposeFile{
+		Services: make(map[string]ComposeService),
+		Volumes:  make(map[string]ComposeVolume),
+		Networks: make(map[string]ComposeNetwork),
+		Secrets:  make(map[string]ComposeSecret),
+		Configs:  make(map[string]ComposeConfig),
+	}
+}
+
+// ServiceKeys returns the YAML keys a service can have.
+func ServiceKeys() []string {
+	keys := make([]string, 0, len(serviceFields()))
+	for key := range serviceFields() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serviceFields maps the YAML keys of ComposeService to their field index.
+func serviceFields() map[string]int {
+	fields := make(map[string]int)
+	serviceType := reflect.TypeOf(ComposeService{})
+	for i := 0; i < serviceType.NumField(); i++ {
+		key, _, _ := strings.Cut(serviceType.Field(i).Tag.Get("yaml"), ",")
+		if key != "" && key != "-" {
+			fields[key] = i
+		}
+	}
+	return fields
+}
+
+// ExcludeFields clears the given keys in every service, so they are left out
+// however they were populated. Unknown keys are ignored.
+func (c *ComposeFile) ExcludeFields(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	fields := serviceFields()
+	for name, service := range c.Services {
+		value := reflect.ValueOf(&service).Elem()
+		for _, key := range keys {
+			if index, ok := fields[key]; ok {
+				field := value.Field(index)
+				field.Set(reflect.Zero(field.Type()))
+			}
+		}
+		c.Services[name] = service
+	}
+}
+
+// Header returns the comment block written above the document, saying which
+// generator wrote it, where and when, and from which containers.
+func (c ComposeFile) Header(generator string, now time.Time) string {
+	var header strings.Builder
+	fmt.Fprintf(&header, "# Generated by %s on %s\n", generator, now.UTC().Format(time.RFC3339))
+	if c.daemonHostname != "" {
+		fmt.Fprintf(&header, "# Docker host: %s\n", c.daemonHostname)
+	}
+	names := make([]string, 0, len(c.Services))
+	for name, service := range c.Services {
+		if service.containerID != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		header.WriteString("# Services:\n")
+	}
+	for _, name := range names {
+		service := c.Services[name]
+		// The repo digest pins the image on any host, the ID only on this one
+		image := service.imageDigest
+		if image == "" {
+			image = service.imageID
+		}
+		fmt.Fprintf(&header, "#   %s: container %s, image %s\n", name, service.containerID[:min(12, len(service.containerID))], image)
+	}
+	return header.String()
+}
+
+// MarshalOptions controls the optional YAML conventions of Node.
+type MarshalOptions struct {
+	EnvList  bool // emit the environment as a list of KEY=VALUE strings
+	Annotate bool // comment on values that were derived or left out
+	Dedupe   bool // factor the keys every service shares into an x-common block
+	Metadata bool // record the source of each service in an x-autocompose mapping
+
+	// Generator names the tool and its version in the metadata
+	Generator string
+}
+
+// serviceMetadata is the x-autocompose mapping recording where a service was
+// exported from.
+type serviceMetadata struct {
+	ContainerID string `yaml:"container_id"`
+	Created     string `yaml:"created,omitempty"`
+	ImageID     string `yaml:"image_id,omitempty"`
+	ImageDigest string `yaml:"image_digest,omitempty"`
+	Generator   string `yaml:"generator,omitempty"`
+}
+
+// Node encodes the compose file into a YAML document, with a commented out
+// build section for the services whose build context is unknown.
+func (c ComposeFile) Node(opts MarshalOptions) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := doc.Encode(c); err != nil {
+		return nil, err
+	}
+	if opts.EnvList {
+		listEnvironment(&doc)
+	}
+	if opts.Dedupe {
+		dedupeServices(&doc)
+	}
+	if opts.Metadata {
+		if err := addMetadata(&doc, c, opts.Generator); err != nil {
+			return nil, err
+		}
+	}
+	commentBuildStubs(&doc, c)
+	if opts.Annotate {
+		annotateServices(&doc, c)
+	}
+	return &doc, nil
+}
+
+// Marshal encodes the compose file into YAML with the default options.
+func (c ComposeFile) Marshal() ([]byte, error) {
+	doc, err := c.Node(MarshalOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// listEnvironment rewrites the environment mappings of the encoded services
+// into the equivalent sequence of KEY=VALUE strings.
+func listEnvironment(doc *yaml.Node) {
+	services := mappingValue(doc, "services")
+	if services == nil {
+		return
+	}
+	for i := 1; i < len(services.Content); i += 2 {
+		env := mappingValue(services.Content[i], "environment")
+		if env == nil {
+			continue
+		}
+		list := make([]*yaml.Node, 0, len(env.Content)/2)
+		for j := 0; j+1 < len(env.Content); j += 2 {
+			// An empty value keeps its "=", "KEY" alone would be taken from the shell
+			list = append(list, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: env.Content[j].Value + "=" + env.Content[j+1].Value})
+		}
+		env.Kind = yaml.SequenceNode
+		env.Tag = ""
+		env.Content = list
+	}
+}
+
+// dedupeServices moves the keys all services set to the same value into an
+// x-common block, merged back into each service with "<<: *common". Mappings
+// such as the environment that only share some entries get those merged the
+// same way from an anchor of their own. Sequences such as ports are merged as
+// a whole by compose, so they stay per service, and so do the keys naming each
+// container.
+func dedupeServices(doc *yaml.Node) {
+	services := mappingValue(doc, "services")
+	if services == nil || len(services.Content) < 4 {
+		return
+	}
+	encoded := func(node *yaml.Node) string {
+		data, _ := yaml.Marshal(node)
+		return string(data)
+	}
+	// sharedEntries returns the entries of mapping that every service has in
+	// its mapping under key
+	sharedEntries := func(key string, mapping *yaml.Node) []*yaml.Node {
+		var entries []*yaml.Node
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			shared := true
+			for j := 3; j < len(services.Content) && shared; j += 2 {
+				otherMapping := mappingValue(services.Content[j], key)
+				if otherMapping == nil {
+					shared = false
+					break
+				}
+				other := mappingValue(otherMapping, mapping.Content[i].Value)
+				shared = other != nil && encoded(other) == encoded(mapping.Content[i+1])
+			}
+			if shared {
+				entries = append(entries, mapping.Content[i], mapping.Content[i+1])
+			}
+		}
+		return entries
+	}
+
+	common := &yaml.Node{Kind: yaml.MappingNode, Anchor: "common"}
+	partial := make(map[string]*yaml.Node)
+	first := services.Content[1]
+	for i := 0; i+1 < len(first.Content); i += 2 {
+		key, value := first.Content[i].Value, first.Content[i+1]
+		if value.Kind == yaml.SequenceNode || key == "container_name" || key == "hostname" {
+			continue
+		}
+		shared := true
+		for j := 3; j < len(services.Content) && shared; j += 2 {
+			other := mappingValue(services.Content[j], key)
+			shared = other != nil && encoded(other) == encoded(value)
+		}
+		// A copy of the key, so that comments added to the service's own key
+		// later stay out of the block
+		keyNode := *first.Content[i]
+		if shared {
+			common.Content = append(common.Content, &keyNode, value)
+		} else if value.Kind == yaml.MappingNode {
+			if entries := sharedEntries(key, value); len(entries) > 0 {
+				node := &yaml.Node{Kind: yaml.MappingNode, Anchor: "common-" + key, Content: entries}
+				common.Content = append(common.Content, &keyNode, node)
+				partial[key] = node
+			}
+		}
+	}
+	if len(common.Content) == 0 {
+		return
+	}
+
+	// merge returns the entries of mapping not in from, or in keep, preceded
+	// by a merge of from
+	merge := func(mapping, from *yaml.Node, keep map[string]*yaml.Node) []*yaml.Node {
+		content := []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "<<"},
+			{Kind: yaml.AliasNode, Value: from.Anchor, Alias: from},
+		}
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if key := mapping.Content[i].Value; mappingKey(from, key) == nil || keep[key] != nil {
+				content = append(content, mapping.Content[i], mapping.Content[i+1])
+			}
+		}
+		return content
+	}
+	for i := 1; i < len(services.Content); i += 2 {
+		service := services.Content[i]
+		for key, from := range partial {
+			mapping := mappingValue(service, key)
+			mapping.Content = merge(mapping, from, nil)
+		}
+		service.Content = merge(service, common, partial)
+	}
+
+	// Place the block above the services, which alias it
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == "services" {
+			block := []*yaml.Node{{Kind: yaml.ScalarNode, Value: "x-common"}, common}
+			doc.Content = append(doc.Content[:i], append(block, doc.Content[i:]...)...)
+			break
+		}
+	}
+}
+
+// addMetadata appends an x-autocompose mapping to every service exported from
+// a container.
+func addMetadata(doc *yaml.Node, compose ComposeFile, generator string) error {
+	services := mappingValue(doc, "services")
+	if services == nil {
+		return nil
+	}
+	for name, service := range compose.Services {
+		node := mappingValue(services, name)
+		if node == nil || service.containerID == "" {
+			continue
+		}
+		var metadata yaml.Node
+		if err := metadata.Encode(serviceMetadata{
+			ContainerID: service.containerID,
+			Created:     service.created,
+			ImageID:     service.imageID,
+			ImageDigest: service.imageDigest,
+			Generator:   generator,
+		}); err != nil {
+			return err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "x-autocompose"}, &metadata)
+	}
+	return nil
+}
+
+// commentBuildStubs adds a commented out build section above the image of the
+// services whose build context could not be found.
+func commentBuildStubs(doc *yaml.Node, compose ComposeFile) {
+	services := mappingValue(doc, "services")
+	if services == nil {
+		return
+	}
+	for name, service := range compose.Services {
+		if !service.buildStub {
+			continue
+		}
+		if node := mappingValue(services, name); node != nil && len(node.Content) > 0 {
+			node.Content[0].HeadComment = "build:\n    context: .\n    dockerfile: Dockerfile"
+		}
+	}
+}
+
+// annotateServices adds the notes of each service as comments, next to the key
+// they explain or above the service when the key was left out.
+func annotateServices(doc *yaml.Node, compose ComposeFile) {
+	services := mappingValue(doc, "services")
+	if services == nil {
+		return
+	}
+	for name, service := range compose.Services {
+		node := mappingValue(services, name)
+		if node == nil || len(node.Content) == 0 {
+			continue
+		}
+		for _, note := range service.notes {
+			if key := mappingKey(node, note.key); key != nil {
+				key.LineComment = strings.TrimPrefix(key.LineComment+"; "+note.text, "; ")
+			} else {
+				first := node.Content[0]
+				first.HeadComment = strings.TrimPrefix(first.HeadComment+"\n"+note.text, "\n")
+			}
+		}
+	}
+}
+
+// mappingKey returns the key node of key in a mapping node, or nil.
+func mappingKey(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value node of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeCompose adds the services, volumes and networks of src into dst. Colliding service
+// names get a numeric suffix so no service is silently overwritten.
+func mergeCompose(dst *ComposeFile, src ComposeFile) {
+	if dst.daemonHostname == "" {
+		dst.daemonHostname = src.daemonHostname
+	}
+	// The project name is only kept when every merged file agrees on it
+	if len(dst.Services) == 0 {
+		dst.Name = src.Name
+	} else if dst.Name != src.Name {
+		dst.Name = ""
+	}
+	for name, service := range src.Services {
+		unique := name
+		for i := 2; ; i++ {
+			if _, exists := dst.Services[unique]; !exists {
+				break
+			}
+			unique = fmt.Sprintf("%s_%d", name, i)
+		}
+		dst.Services[unique] = service
+	}
+	for name, vol := range src.Volumes {
+		dst.Volumes[name] = vol
+	}
+	for name, net := range src.Networks {
+		dst.Networks[name] = net
+	}
+	for name, secret := range src.Secrets {
+		dst.Secrets[name] = secret
+	}
+	for name, config := range src.Configs {
+		dst.Configs[name] = config
+	}
+}
@@ -0,0 +1,139 @@
+package autocompose
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// marshalNode encodes c with opts and checks that the result parses again.
+func marshalNode(t *testing.T, c ComposeFile, opts MarshalOptions) string {
+	t.Helper()
+	doc, err := c.Node(opts)
+	if err != nil {
+		t.Fatalf("Node: %v", err)
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("output does not parse: %v\n%s", err, data)
+	}
+	return string(data)
+}
+
+func TestDedupeServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		services map[string]ComposeService
+		want     []string
+		notWant  []string
+	}{
+		{
+			name: "mapping missing from a service",
+			services: map[string]ComposeService{
+				"a": {Image: "x", Environment: StringMap{"A": "1"}},
+				"b": {Image: "x"},
+			},
+			want:    []string{"x-common: &common\n    image: x\n", "<<: *common"},
+			notWant: []string{"common-environment"},
+		},
+		{
+			name: "partly shared environment",
+			services: map[string]ComposeService{
+				"a": {Image: "x", Environment: StringMap{"A": "1", "ID": "1"}},
+				"b": {Image: "x", Environment: StringMap{"A": "1", "ID": "2"}},
+			},
+			want: []string{"environment: &common-environment\n        A: \"1\"\n", "<<: *common-environment\n            ID: \"2\"\n"},
+		},
+		{
+			name: "lists stay per service",
+			services: map[string]ComposeService{
+				"a": {Image: "x", Ports: []ComposeServicePort{{Short: "80"}}},
+				"b": {Image: "x", Ports: []ComposeServicePort{{Short: "80"}}},
+			},
+			want:    []string{"x-common: &common\n    image: x\nservices:"},
+			notWant: []string{"x-common: &common\n    image: x\n    ports"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := marshalNode(t, ComposeFile{Services: tt.services}, MarshalOptions{Dedupe: true})
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output lacks %q:\n%s", want, out)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("output has %q:\n%s", notWant, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupeServicesAnnotated(t *testing.T) {
+	a := ComposeService{Image: "x", Environment: StringMap{"A": "1", "ID": "1"}}
+	a.note("environment", "3 inherited")
+	b := ComposeService{Image: "x", Environment: StringMap{"A": "1", "ID": "2"}}
+	out := marshalNode(t, ComposeFile{Services: map[string]ComposeService{"a": a, "b": b}}, MarshalOptions{Dedupe: true, Annotate: true})
+	common, _, _ := strings.Cut(out, "services:")
+	if strings.Contains(common, "3 inherited") {
+		t.Errorf("service note inside x-common:\n%s", out)
+	}
+}
+
+func TestStringMapRoundTrip(t *testing.T) {
+	values := []string{"yes", "no", "on", "3.0", "0x1A", "true", "08080", "null", "", " padded ", "plain"}
+	for _, value := range values {
+		if value != "plain" && !needsQuoting(value) {
+			t.Errorf("needsQuoting(%q) = false", value)
+		}
+	}
+
+	env := make(StringMap)
+	for i, value := range values {
+		env[fmt.Sprintf("V%d", i)] = value
+	}
+	data, err := yaml.Marshal(ComposeService{Environment: env, Labels: env})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Environment map[string]interface{} `yaml:"environment"`
+		Labels      map[string]interface{} `yaml:"labels"`
+	}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range env {
+		if got := parsed.Environment[key]; got != value {
+			t.Errorf("environment %s = %#v, want %q", key, got, value)
+		}
+		if got := parsed.Labels[key]; got != value {
+			t.Errorf("label %s = %#v, want %q", key, got, value)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	compose := ComposeFile{Services: map[string]ComposeService{
+		"db":  {containerID: "0123456789abcdef", imageID: "sha256:aaaa"},
+		"web": {containerID: "fedcba9876543210", imageID: "sha256:bbbb", imageDigest: "nginx@sha256:cccc"},
+	}}
+	got := compose.Header("docker-autocompose test", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	want := `# Generated by docker-autocompose test on 2024-01-02T03:04:05Z
+# Services:
+#   db: container 0123456789ab, image sha256:aaaa
+#   web: container fedcba987654, image nginx@sha256:cccc
+`
+	if got != want {
+		t.Errorf("header =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,1573 @@
+// Package autocompose generates compose files from the containers and swarm
+// services of a Docker or podman engine.
+package autocompose
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// Options controls how containers are translated into compose services.
+type Options struct {
+	Engine               string // EngineDocker or EnginePodman, EngineDocker when empty
+	NoPortRanges         bool   // emit one ports entry per port instead of collapsing ranges
+	PinDigest            bool   // reference the image by its repo digest instead of its tag
+	BuildHint            bool   // add a build section for images that were never pushed
+	ProfileLabel         string // container label holding the comma separated compose profiles
+	LongMounts           bool   // emit every mount in the long volume syntax
+	KeepAnonymousVolumes bool   // keep anonymous volumes by name instead of recreating them
+	Project              string // export the containers of this compose project under their service names
+	FullEnv              bool   // emit every environment variable, including ones inherited from the image
+	KeepHostname         bool   // emit the hostname even when it looks generated by the engine
+	PinAssignedPorts     bool   // keep the host ports the engine assigned to bindings that asked for none
+	BindBase             string // absolute directory bind sources under it are written relative to
+	ProjectName          string // top-level name, instead of the compose project of the containers
+	LiteralNames         bool   // name services after their container even when compose created it
+	Jobs                 int    // containers inspected concurrently, 4 per CPU when 0
+
+	// Container label key prefixes left out of the services, the
+	// DefaultIgnoreLabelPrefixes when nil and none when empty
+	IgnoreLabelPrefixes []string
+}
+
+// DefaultIgnoreLabelPrefixes are the labels compose and common tools set on
+// containers and images on their own. Traefik labels are not among them, as
+// they hold the routing set up for the container.
+var DefaultIgnoreLabelPrefixes = []string{
+	"com.docker.compose.",
+	"com.centurylinklabs.watchtower.",
+	"io.portainer.",
+	"org.opencontainers.",
+	"org.label-schema.",
+}
+
+// ignoredLabel reports whether key starts with one of the ignored prefixes.
+func (g *generator) ignoredLabel(key string) bool {
+	prefixes := g.opts.IgnoreLabelPrefixes
+	if prefixes == nil {
+		prefixes = DefaultIgnoreLabelPrefixes
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client is the part of the Docker API the generation and container selection
+// use. *client.Client implements it, InspectClient answers from saved inspect
+// output, and other implementations can wrap or replace the daemon.
+type Client interface {
+	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error)
+	VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
+	DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error)
+	Info(ctx context.Context) (system.Info, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	ServiceInspectWithRaw(ctx context.Context, serviceID string, opts types.ServiceInspectOptions) (swarm.Service, []byte, error)
+}
+
+var _ Client = (*client.Client)(nil)
+
+// Warning is a setting that was left out or could not be represented, which
+// did not stop the export.
+type Warning struct {
+	Service string // container or service the warning concerns, if any
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Service == "" {
+		return w.Message
+	}
+	return w.Service + ": " + w.Message
+}
+
+// generator holds what the generation of one compose file shares.
+type generator struct {
+	ctx      context.Context
+	cli      Client
+	opts     Options
+	warnings []Warning
+	err      error // first lookup that failed, which fails the export
+}
+
+func (g *generator) warn(service, format string, args ...interface{}) {
+	g.warnings = append(g.warnings, Warning{Service: service, Message: fmt.Sprintf(format, args...)})
+}
+
+// lookupFailed reports whether a lookup failed for another reason than the
+// object not existing, such as the daemon not answering in time, and records
+// the error so the export fails instead of guessing around the answer.
+func (g *generator) lookupFailed(err error) bool {
+	if err == nil || client.IsErrNotFound(err) {
+		return false
+	}
+	if g.err == nil {
+		g.err = err
+	}
+	return true
+}
+
+// Generate inspects the given containers and translates them into one compose
+// file. Containers are inspected concurrently, and the images, volumes and
+// networks they share only once. Containers that cannot be inspected are left
+// out and their errors returned joined, along with the file of the others.
+func Generate(ctx context.Context, cli Client, containerIDs []string, opts Options) (ComposeFile, []Warning, error) {
+	cli = &cachingClient{Client: cli}
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = 4 * runtime.NumCPU()
+	}
+
+	// Results are kept in argument order whatever order they complete in
+	type result struct {
+		compose  ComposeFile
+		warnings []Warning
+		err      error
+	}
+	results := make([]result, len(containerIDs))
+	slots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, containerID := range containerIDs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			g := &generator{ctx: ctx, cli: cli, opts: opts}
+			containerJSON, imageJSON, err := g.inspectContainer(containerID)
+			if err == nil {
+				results[i].compose = g.generateCompose(containerJSON, imageJSON)
+				if g.err != nil {
+					err = fmt.Errorf("Error inspecting container %s: %w", containerID, g.err)
+				}
+			}
+			results[i].warnings, results[i].err = g.warnings, err
+		}()
+	}
+	wg.Wait()
+
+	g := &generator{ctx: ctx, cli: cli, opts: opts}
+	compose := newComposeFile()
+	var projects []string
+	var errs []error
+	for _, result := range results {
+		g.warnings = append(g.warnings, result.warnings...)
+		if result.err != nil {
+			errs = append(errs, result.err)
+			continue
+		}
+		mergeCompose(&compose, result.compose)
+		projects = append(projects, result.compose.Name)
+	}
+	g.nameProject(&compose, projects)
+	g.linkServices(&compose)
+	return compose, g.warnings, errors.Join(errs...)
+}
+
+// nameProject sets the top-level name to ProjectName, or leaves the name of
+// the project every container belongs to, warning when they belong to
+// different ones.
+func (g *generator) nameProject(compose *ComposeFile, projects []string) {
+	if g.opts.ProjectName != "" {
+		compose.Name = g.opts.ProjectName
+		return
+	}
+	var names []string
+	for _, project := range projects {
+		if project == "" {
+			project = "none"
+		}
+		if !slices.Contains(names, project) {
+			names = append(names, project)
+		}
+	}
+	if len(names) > 1 {
+		sort.Strings(names)
+		g.warn("", "the containers belong to different compose projects (%s), leaving out the top-level name", strings.Join(names, ", "))
+	}
+}
+
+// inspectContainer inspects a container and the image it was created from,
+// adjusting podman's responses to what the docker engine would report.
+func (g *generator) inspectContainer(containerID string) (container.InspectResponse, image.InspectResponse, error) {
+	containerJSON, err := g.cli.ContainerInspect(g.ctx, containerID)
+	if err != nil {
+		return containerJSON, image.InspectResponse{}, fmt.Errorf("Error inspecting container %s: %w", containerID, err)
+	}
+	if g.opts.Engine == EnginePodman {
+		normalizePodmanContainer(&containerJSON)
+	}
+
+	// Compare against the image the container was created from, which its tag
+	// may no longer point to
+	imageJSON, err := g.cli.ImageInspect(g.ctx, containerJSON.Image)
+	if err != nil && containerJSON.Config.Image != "" && !isImageID(containerJSON.Config.Image, containerJSON.Image) {
+		if imageJSON, err = g.cli.ImageInspect(g.ctx, containerJSON.Config.Image); err == nil {
+			g.warn(containerJSON.Name[1:], "its image was removed, comparing against the current %s", containerJSON.Config.Image)
+		}
+	}
+	if err != nil {
+		if g.opts.Engine != EnginePodman {
+			return containerJSON, imageJSON, fmt.Errorf("Error inspecting image %s: %w", containerJSON.Config.Image, err)
+		}
+		// Podman can lose track of the image of a running container, compare
+		// against an empty image so every setting is exported
+		g.warn(containerJSON.Name[1:], "cannot inspect image %s, exporting all container settings: %v", containerJSON.Config.Image, err)
+		imageJSON = image.InspectResponse{}
+	}
+	if imageJSON.Config == nil {
+		imageJSON.Config = &container.Config{}
+	}
+	return containerJSON, imageJSON, nil
+}
+
+func (g *generator) generateCompose(containerJSON container.InspectResponse, imageJSON image.InspectResponse) ComposeFile {
+	compose := newComposeFile()
+
+	service := ComposeService{
+		Image:         containerJSON.Config.Image,
+		Ports:         make([]ComposeServicePort, 0),
+		Volumes:       make([]ComposeServiceVolume, 0),
+		ContainerName: containerJSON.Name[1:], // Remove leading '/'
+		Environment:   make(map[string]string),
+		Networks:      make(ComposeServiceNetworks),
+		CapAdd:        containerJSON.HostConfig.CapAdd,
+		CapDrop:       containerJSON.HostConfig.CapDrop,
+		Privileged:    containerJSON.HostConfig.Privileged,
+		Healthcheck:   nil,
+		Cmd:           nil,
+		Entrypoint:    nil,
+		Labels:        make(map[string]string),
+		Hostname:      "",
+		WorkingDir:    "",
+		StopSignal:    containerJSON.Config.StopSignal,
+		Shell:         containerJSON.Config.Shell,
+	}
+
+	// Containers created from an image ID, which cannot be pulled and is gone
+	// once the image is pruned
+	if isImageID(containerJSON.Config.Image, imageJSON.ID) {
+		if name := imageName(imageJSON, g.opts.PinDigest); name != "" {
+			service.Image = name
+			service.note("image", "the container was created from image ID %s", containerJSON.Config.Image)
+		} else {
+			g.warn(containerJSON.Name[1:], "image %s has no tag or digest to reference it by", containerJSON.Config.Image)
+		}
+	}
+
+	if g.opts.PinDigest {
+		service.Image = g.pinnedImage(service.Image, imageJSON)
+	}
+
+	// Images that were never pushed cannot be pulled elsewhere
+	if g.opts.BuildHint && len(imageJSON.RepoDigests) == 0 {
+		service.Build = buildHint(containerJSON, imageJSON)
+		if service.Build == nil {
+			service.buildStub = true
+			g.warn(containerJSON.Name[1:], "image %s was built locally and its build context is unknown, fill in the build section", containerJSON.Config.Image)
+		}
+	}
+
+	// "no" is the compose default
+	restartPolicy := containerJSON.HostConfig.RestartPolicy
+	if !restartPolicy.IsNone() {
+		service.Restart = string(restartPolicy.Name)
+		if restartPolicy.IsOnFailure() && restartPolicy.MaximumRetryCount > 0 {
+			service.Restart += ":" + strconv.Itoa(restartPolicy.MaximumRetryCount)
+		}
+	}
+
+	networkMode := containerJSON.HostConfig.NetworkMode
+	if containerJSON.Config.NetworkDisabled {
+		// Compose has no network_disabled, a container without networking
+		// is the same as one on the none network
+		networkMode = network.NetworkNone
+	}
+	if networkMode.IsHost() || networkMode.IsNone() {
+		service.NetworkMode = string(networkMode)
+	}
+
+	// Namespaces shared with another container are resolved by linkServices
+	if networkMode.IsContainer() {
+		service.namespaces = append(service.namespaces, g.namespaceRef("network_mode", networkMode.ConnectedContainer()))
+	}
+	if ipcMode := containerJSON.HostConfig.IpcMode; ipcMode.IsHost() {
+		service.Ipc = "host"
+	} else if ipcMode.IsContainer() {
+		service.namespaces = append(service.namespaces, g.namespaceRef("ipc", ipcMode.Container()))
+	}
+	if pidMode := containerJSON.HostConfig.PidMode; pidMode.IsHost() {
+		service.Pid = "host"
+	} else if pidMode.IsContainer() {
+		service.namespaces = append(service.namespaces, g.namespaceRef("pid", pidMode.Container()))
+	}
+
+	portBindings := containerJSON.HostConfig.PortBindings
+	if networkMode.IsHost() {
+		// Published ports are meaningless on the host network
+		if len(portBindings) > 0 {
+			g.warn(service.ContainerName, "%d published ports left out, they do not apply to the host network", len(portBindings))
+			service.note("network_mode", "%d published ports omitted, they do not apply to the host network", len(portBindings))
+		}
+		portBindings = nil
+	}
+
+	// -P publishes every exposed port that has no binding of its own
+	if containerJSON.HostConfig.PublishAllPorts && !networkMode.IsHost() {
+		portBindings = maps.Clone(portBindings)
+		if portBindings == nil {
+			portBindings = make(nat.PortMap)
+		}
+		for p := range containerJSON.Config.ExposedPorts {
+			if _, bound := portBindings[p]; !bound {
+				portBindings[p] = []nat.PortBinding{{}}
+			}
+		}
+	}
+
+	var mappings []portMapping
+	// Bindings on 0.0.0.0 and :: only differ by address family
+	seenMappings := make(map[portMapping]bool)
+	ephemeral := 0
+	for p, bindings := range portBindings {
+		for _, binding := range bindings {
+			mapping := portMapping{
+				hostPort:      binding.HostPort,
+				containerPort: p.Int(),
+				proto:         p.Proto(),
+				count:         1,
+			}
+			if !isWildcardIP(binding.HostIP) {
+				mapping.hostIP = binding.HostIP
+			}
+			// No host port was asked for, the engine picked a random one
+			if mapping.hostPort == "" && g.opts.PinAssignedPorts {
+				mapping.hostPort = assignedPort(containerJSON, p, binding.HostIP)
+			}
+			if seenMappings[mapping] {
+				continue
+			}
+			seenMappings[mapping] = true
+			if mapping.hostPort == "" {
+				ephemeral++
+			}
+			mappings = append(mappings, mapping)
+		}
+	}
+	if ephemeral > 0 {
+		service.note("ports", "%d host ports assigned by the engine left for it to assign again", ephemeral)
+	}
+	sortPortMappings(mappings)
+	if !g.opts.NoPortRanges {
+		mappings = collapsePortRanges(mappings)
+	}
+	for _, mapping := range mappings {
+		service.Ports = append(service.Ports, ComposeServicePort{Short: mapping.String()})
+	}
+
+	// Exposed ports added by the container that are not published, which mean
+	// nothing on the host network either
+	exposedPorts := containerJSON.Config.ExposedPorts
+	if networkMode.IsHost() {
+		exposedPorts = nil
+	}
+	for p := range exposedPorts {
+		if _, inImage := imageJSON.Config.ExposedPorts[p]; inImage {
+			continue
+		}
+		if _, published := portBindings[p]; published {
+			continue
+		}
+		exposed := p.Port()
+		if p.Proto() != "tcp" {
+			exposed += "/" + p.Proto()
+		}
+		service.Expose = append(service.Expose, exposed)
+	}
+	sort.Strings(service.Expose)
+
+	// Mounts are reported in no particular order, keep the output stable
+	mounts := append([]container.MountPoint(nil), containerJSON.Mounts...)
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return mounts[i].Destination < mounts[j].Destination
+	})
+
+	// Swarm mounts the secrets of a service task under /run/secrets and its
+	// configs from the configs directory of the container
+	_, swarmTask := containerJSON.Config.Labels["com.docker.swarm.task.id"]
+	configsDir := "/" + containerJSON.ID + "/configs/"
+
+	// Mounts inherited through --volumes-from, by destination
+	inherited := make(map[string]*volumesFromRef)
+	for _, ref := range containerJSON.HostConfig.VolumesFrom {
+		name, mode, _ := strings.Cut(ref, ":")
+		source, err := g.cli.ContainerInspect(g.ctx, name)
+		if g.lookupFailed(err) {
+			continue
+		} else if err != nil {
+			g.warn(service.ContainerName, "cannot inspect volumes_from container %s: %v", name, err)
+			continue
+		}
+		from := &volumesFromRef{containerID: source.ID, containerName: source.Name[1:], readOnly: mode == "ro"}
+		service.volumesFrom = append(service.volumesFrom, from)
+		for _, mountPoint := range source.Mounts {
+			inherited[mountPoint.Destination] = from
+		}
+	}
+
+	// Volume mapping distinction
+	var anonymized []string
+	for _, mountPoint := range mounts {
+		hostMount := findHostMount(mountPoint.Destination, containerJSON.HostConfig.Mounts)
+		if swarmTask && strings.HasPrefix(mountPoint.Destination, "/run/secrets/") {
+			name := path.Base(mountPoint.Destination)
+			secret := ComposeFileReference{Source: name}
+			if mountPoint.Destination != "/run/secrets/"+name {
+				secret.Target = mountPoint.Destination
+			}
+			service.Secrets = append(service.Secrets, secret)
+			compose.Secrets[name] = ComposeSecret{External: true, Name: name}
+		} else if swarmTask && strings.Contains(filepath.ToSlash(mountPoint.Source), configsDir) {
+			name := path.Base(mountPoint.Destination)
+			config := ComposeFileReference{Source: name}
+			if mountPoint.Destination != "/"+name {
+				config.Target = mountPoint.Destination
+			}
+			service.Configs = append(service.Configs, config)
+			compose.Configs[name] = ComposeConfig{External: true, Name: name}
+		} else if mountPoint.Type == mount.TypeVolume {
+			// Docker volume
+			volumeInspect, err := g.cli.VolumeInspect(g.ctx, mountPoint.Name)
+			if g.lookupFailed(err) {
+				continue
+			}
+			if !g.opts.KeepAnonymousVolumes && isAnonymousVolume(mountPoint.Name, volumeInspect) {
+				// Let compose create a fresh anonymous volume, the short
+				// syntax can only express a writable one
+				if g.opts.LongMounts || !mountPoint.RW {
+					service.Volumes = append(service.Volumes, serviceVolume("", mountPoint, hostMount, true))
+				} else {
+					service.Volumes = append(service.Volumes, ComposeServiceVolume{Short: mountPoint.Destination})
+				}
+				anonymized = append(anonymized, mountPoint.Destination)
+				continue
+			}
+			volumeKey := mountPoint.Name
+			composeVolume := ComposeVolume{
+				Name:     mountPoint.Name,
+				External: err != nil || !isComposeVolume(volumeInspect),
+			}
+			if !composeVolume.External {
+				if volumeInspect.Driver != "local" {
+					composeVolume.Driver = volumeInspect.Driver
+				}
+				composeVolume.DriverOpts = volumeInspect.Options
+				for key, value := range volumeInspect.Labels {
+					if !strings.HasPrefix(key, "com.docker.compose.") {
+						if composeVolume.Labels == nil {
+							composeVolume.Labels = make(StringMap)
+						}
+						composeVolume.Labels[key] = value
+					}
+				}
+				// Volumes of the exported project are declared by their unprefixed name
+				if g.opts.Project != "" && volumeInspect.Labels["com.docker.compose.project"] == g.opts.Project &&
+					volumeInspect.Labels["com.docker.compose.volume"] != "" {
+					volumeKey = volumeInspect.Labels["com.docker.compose.volume"]
+					composeVolume.Name = ""
+				}
+			}
+			service.Volumes = append(service.Volumes, serviceVolume(volumeKey, mountPoint, hostMount, g.opts.LongMounts))
+			compose.Volumes[volumeKey] = composeVolume
+		} else if mountPoint.Type == mount.TypeBind {
+			// Local folder
+			service.Volumes = append(service.Volumes, serviceVolume(g.bindSource(mountPoint.Source), mountPoint, hostMount, g.opts.LongMounts))
+		} else if mountPoint.Type == mount.TypeTmpfs {
+			// In-memory mount created with --mount type=tmpfs
+			if g.opts.LongMounts {
+				service.Volumes = append(service.Volumes, serviceVolume("", mountPoint, hostMount, true))
+			} else {
+				service.Tmpfs = append(service.Tmpfs, tmpfsMapping(mountPoint.Destination, hostMount))
+			}
+		} else {
+			g.warn(service.ContainerName, "%s mount at %s cannot be represented in compose and was skipped", mountPoint.Type, mountPoint.Destination)
+		}
+	}
+	if len(anonymized) > 0 {
+		g.warn(service.ContainerName, "anonymous volumes at %s are exported without their data, use --keep-anonymous-volumes to reuse them", strings.Join(anonymized, ", "))
+		service.note("volumes", "anonymous volumes at %s are recreated empty", strings.Join(anonymized, ", "))
+	}
+	if len(inherited) > 0 {
+		own := make([]ComposeServiceVolume, 0, len(service.Volumes))
+		for _, entry := range service.Volumes {
+			if from := inherited[entry.Target()]; from != nil {
+				from.volumes = append(from.volumes, entry)
+			} else {
+				own = append(own, entry)
+			}
+		}
+		service.Volumes = own
+	}
+
+	// tmpfs mounts created with --tmpfs
+	for path, options := range containerJSON.HostConfig.Tmpfs {
+		if options != "" {
+			path += ":" + options
+		}
+		service.Tmpfs = append(service.Tmpfs, path)
+	}
+	sort.Strings(service.Tmpfs)
+
+	// DNS settings, each emitted independently only when set
+	if len(containerJSON.HostConfig.DNS) > 0 {
+		service.Dns = containerJSON.HostConfig.DNS
+	}
+	if len(containerJSON.HostConfig.DNSSearch) > 0 {
+		service.DnsSearch = containerJSON.HostConfig.DNSSearch
+	}
+	if len(containerJSON.HostConfig.DNSOptions) > 0 {
+		service.DnsOptions = containerJSON.HostConfig.DNSOptions
+	}
+
+	if len(containerJSON.HostConfig.ExtraHosts) > 0 {
+		service.ExtraHosts = append([]string(nil), containerJSON.HostConfig.ExtraHosts...)
+		sort.Strings(service.ExtraHosts)
+	}
+
+	for _, ulimit := range containerJSON.HostConfig.Ulimits {
+		if service.Ulimits == nil {
+			service.Ulimits = make(map[string]ComposeUlimit)
+		}
+		service.Ulimits[ulimit.Name] = ComposeUlimit{Soft: ulimit.Soft, Hard: ulimit.Hard}
+	}
+
+	// Runtime and cgroup namespace comparison against the daemon defaults
+	defaultRuntime, defaultCgroupns, daemonOS, daemonArch, daemonHostname := "runc", container.CgroupnsModePrivate, "", "", ""
+	if info, err := g.cli.Info(g.ctx); !g.lookupFailed(err) {
+		daemonHostname = info.Name
+		if info.DefaultRuntime != "" {
+			defaultRuntime = info.DefaultRuntime
+		}
+		if info.CgroupVersion == "1" {
+			defaultCgroupns = container.CgroupnsModeHost
+		}
+		daemonOS, daemonArch = info.OSType, normalizeArch(info.Architecture)
+	}
+	if containerJSON.HostConfig.Runtime != "" && containerJSON.HostConfig.Runtime != defaultRuntime {
+		service.Runtime = containerJSON.HostConfig.Runtime
+	}
+	// Platform is only pinned when the image was built for another architecture
+	if daemonArch != "" && imageJSON.Architecture != "" &&
+		(imageJSON.Architecture != daemonArch || (imageJSON.Os != "" && imageJSON.Os != daemonOS)) {
+		service.Platform = imageJSON.Os + "/" + imageJSON.Architecture
+		if imageJSON.Variant != "" {
+			service.Platform += "/" + imageJSON.Variant
+		}
+	}
+	if cgroupns := containerJSON.HostConfig.CgroupnsMode; !cgroupns.IsEmpty() && cgroupns != defaultCgroupns {
+		service.Cgroup = string(cgroupns)
+	}
+
+	// GPU and other device reservations (--gpus)
+	for _, request := range containerJSON.HostConfig.DeviceRequests {
+		if service.Deploy == nil {
+			service.Deploy = &ComposeDeploy{Resources: ComposeResources{Reservations: &ComposeReservations{}}}
+		}
+		service.Deploy.Resources.Reservations.Devices = append(service.Deploy.Resources.Reservations.Devices, deviceRequest(request))
+	}
+
+	if len(containerJSON.HostConfig.GroupAdd) > 0 {
+		service.GroupAdd = containerJSON.HostConfig.GroupAdd
+	}
+
+	for _, device := range containerJSON.HostConfig.Devices {
+		deviceMapping := device.PathOnHost + ":" + device.PathInContainer
+		if device.CgroupPermissions != "" && device.CgroupPermissions != "rwm" {
+			deviceMapping += ":" + device.CgroupPermissions
+		}
+		service.Devices = append(service.Devices, deviceMapping)
+	}
+
+	containerEnv := parseEnv(containerJSON.Config.Env)
+	imageEnv := parseEnv(imageJSON.Config.Env)
+
+	inheritedEnv := 0
+	for key, value := range containerEnv {
+		// FOO= on an image without FOO still sets it, to the empty string
+		if imageValue, ok := imageEnv[key]; g.opts.FullEnv || !ok || imageValue != value {
+			service.Environment[key] = value
+		} else {
+			inheritedEnv++
+		}
+	}
+	if inheritedEnv > 0 {
+		service.note("environment", "%d variables inherited from the image omitted", inheritedEnv)
+	}
+
+	// The daemon waits 10 seconds unless the container or its image says otherwise
+	if stopTimeout := containerJSON.Config.StopTimeout; stopTimeout != nil {
+		imageTimeout := 10
+		if imageJSON.Config.StopTimeout != nil {
+			imageTimeout = *imageJSON.Config.StopTimeout
+		}
+		if *stopTimeout != imageTimeout {
+			service.StopGracePeriod = strconv.Itoa(*stopTimeout) + "s"
+		}
+	}
+
+	// --cpus sets NanoCPUs, --cpu-quota and --cpu-period the CFS values
+	if containerJSON.HostConfig.NanoCPUs > 0 {
+		service.Cpus = fmt.Sprintf("%.2f", float64(containerJSON.HostConfig.NanoCPUs)/1e9)
+	} else if containerJSON.HostConfig.CPUQuota > 0 {
+		period := containerJSON.HostConfig.CPUPeriod
+		if period == 0 {
+			period = 100000 // kernel default of 100ms
+		}
+		service.Cpus = fmt.Sprintf("%.2f", float64(containerJSON.HostConfig.CPUQuota)/float64(period))
+	}
+
+	service.Cpuset = containerJSON.HostConfig.CpusetCpus
+	if containerJSON.HostConfig.CpusetMems != "" {
+		// The compose spec has no key for memory node pinning
+		g.warn(service.ContainerName, "cpuset mems %q cannot be represented in compose and was skipped", containerJSON.HostConfig.CpusetMems)
+	}
+
+	if containerJSON.HostConfig.Memory > 0 {
+		service.MemLimit = formatBytes(containerJSON.HostConfig.Memory)
+	}
+
+	if containerJSON.HostConfig.MemoryReservation > 0 {
+		memReservation := formatBytes(containerJSON.HostConfig.MemoryReservation)
+		if service.Deploy != nil && service.Deploy.Resources.Reservations != nil {
+			// Compose rejects mem_reservation next to a reservations block
+			service.Deploy.Resources.Reservations.Memory = memReservation
+		} else {
+			service.MemReservation = memReservation
+		}
+	}
+
+	// The daemon defaults the swap limit to twice the memory limit, so only an
+	// explicit value is exported. -1 (unlimited) must stay a bare integer.
+	memory, swap := containerJSON.HostConfig.Memory, containerJSON.HostConfig.MemorySwap
+	if memory > 0 && swap != 0 && swap != memory*2 {
+		if swap < 0 {
+			service.MemswapLimit = -1
+		} else {
+			service.MemswapLimit = formatBytes(swap)
+		}
+	}
+
+	if containerJSON.HostConfig.OomKillDisable != nil {
+		service.OomKillDisable = *containerJSON.HostConfig.OomKillDisable
+	}
+	service.OomScoreAdj = containerJSON.HostConfig.OomScoreAdj
+
+	// A nil init pointer means the daemon default, an explicit false is kept
+	service.Init = containerJSON.HostConfig.Init
+
+	// A nil or zero pids limit means unset, -1 means unlimited
+	if pidsLimit := containerJSON.HostConfig.PidsLimit; pidsLimit != nil && *pidsLimit != 0 {
+		service.PidsLimit = pidsLimit
+	}
+
+	// Block IO weights and throttles
+	hostConfig := containerJSON.HostConfig
+	blkio := ComposeBlkioConfig{Weight: hostConfig.BlkioWeight}
+	for _, device := range hostConfig.BlkioWeightDevice {
+		blkio.WeightDevice = append(blkio.WeightDevice, ComposeWeightDevice{
+			Path:   device.Path,
+			Weight: device.Weight,
+		})
+	}
+	for _, device := range hostConfig.BlkioDeviceReadBps {
+		blkio.DeviceReadBps = append(blkio.DeviceReadBps, ComposeThrottleDevice{device.Path, formatRate(device.Rate)})
+	}
+	for _, device := range hostConfig.BlkioDeviceWriteBps {
+		blkio.DeviceWriteBps = append(blkio.DeviceWriteBps, ComposeThrottleDevice{device.Path, formatRate(device.Rate)})
+	}
+	for _, device := range hostConfig.BlkioDeviceReadIOps {
+		blkio.DeviceReadIops = append(blkio.DeviceReadIops, ComposeThrottleDevice{device.Path, device.Rate})
+	}
+	for _, device := range hostConfig.BlkioDeviceWriteIOps {
+		blkio.DeviceWriteIops = append(blkio.DeviceWriteIops, ComposeThrottleDevice{device.Path, device.Rate})
+	}
+	if blkio.Weight > 0 || len(blkio.WeightDevice) > 0 || len(blkio.DeviceReadBps) > 0 || len(blkio.DeviceWriteBps) > 0 ||
+		len(blkio.DeviceReadIops) > 0 || len(blkio.DeviceWriteIops) > 0 {
+		service.BlkioConfig = &blkio
+	}
+
+	// Network filtering
+	if service.NetworkMode == "" {
+		engineAliases := map[string]bool{service.ContainerName: true}
+		if len(containerJSON.ID) >= 12 {
+			engineAliases[containerJSON.ID[:12]] = true
+		}
+		if composeService := containerJSON.Config.Labels["com.docker.compose.service"]; composeService != "" {
+			engineAliases[composeService] = true
+		}
+		userNetworks := 0
+		for networkName := range containerJSON.NetworkSettings.Networks {
+			if !isBuiltInNetwork(networkName) {
+				userNetworks++
+			}
+		}
+		for networkName, endpoint := range containerJSON.NetworkSettings.Networks {
+			if isBuiltInNetwork(networkName) {
+				continue
+			}
+			networkInspect, err := g.cli.NetworkInspect(g.ctx, networkName, network.InspectOptions{})
+			if g.lookupFailed(err) {
+				continue
+			}
+			isDefault := err == nil && isComposeNetwork(networkInspect) && networkInspect.Labels["com.docker.compose.network"] == "default"
+			if isDefault && userNetworks == 1 {
+				// The project default network is implicit
+				service.note("networks", "compose default network %s omitted", networkName)
+				continue
+			}
+			networkKey := networkName
+			composeNetwork := ComposeNetwork{
+				Name:     networkName,
+				External: err != nil || !isComposeNetwork(networkInspect),
+			}
+			if isDefault {
+				// Listing other networks drops the implicit default one, it
+				// has to be listed too, under the key compose gives it
+				networkKey = "default"
+			}
+			// Networks of the exported project are declared by their unprefixed name
+			if !composeNetwork.External && g.opts.Project != "" && networkInspect.Labels["com.docker.compose.project"] == g.opts.Project &&
+				networkInspect.Labels["com.docker.compose.network"] != "" {
+				networkKey = networkInspect.Labels["com.docker.compose.network"]
+				composeNetwork.Name = ""
+			}
+			service.Networks[networkKey] = serviceNetwork(endpoint, engineAliases)
+			compose.Networks[networkKey] = composeNetwork
+		}
+	}
+
+	// Healthcheck comparison
+	if healthcheckDisabled(containerJSON.Config.Healthcheck) {
+		// --no-healthcheck only matters when the image defines one
+		if imageJSON.Config.Healthcheck != nil && len(imageJSON.Config.Healthcheck.Test) > 0 && !healthcheckDisabled(imageJSON.Config.Healthcheck) {
+			service.Healthcheck = &ComposeHealthcheck{Disable: true}
+		}
+	} else if containerJSON.Config.Healthcheck != nil {
+		if imageJSON.Config.Healthcheck == nil || !healthchecksEqual(containerJSON.Config.Healthcheck, imageJSON.Config.Healthcheck) {
+			service.Healthcheck = composeHealthcheck(containerJSON.Config.Healthcheck)
+		}
+	}
+
+	// Optional services are marked with a comma separated list of profiles
+	for _, profile := range strings.Split(containerJSON.Config.Labels[g.opts.ProfileLabel], ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			service.Profiles = append(service.Profiles, profile)
+		}
+	}
+
+	// Label comparison
+	omittedLabels := 0
+	for key, value := range containerJSON.Config.Labels {
+		if imageJSON.Config.Labels[key] != value && !g.ignoredLabel(key) && !isEngineLabel(key, g.opts.Engine) {
+			service.Labels[key] = value
+		} else {
+			omittedLabels++
+		}
+	}
+	if omittedLabels > 0 {
+		service.note("labels", "%d labels from the image, the engine or with an ignored prefix omitted", omittedLabels)
+	}
+
+	// User, domain name and terminal settings comparison, an empty value
+	// means the image default
+	if user := containerJSON.Config.User; user != "" && user != imageJSON.Config.User {
+		service.User = user
+	}
+	if domainname := containerJSON.Config.Domainname; domainname != "" && domainname != imageJSON.Config.Domainname {
+		service.Domainname = domainname
+	}
+	service.Tty = containerJSON.Config.Tty && !imageJSON.Config.Tty
+	service.StdinOpen = containerJSON.Config.OpenStdin && !imageJSON.Config.OpenStdin
+	service.StdinOnce = containerJSON.Config.StdinOnce && !imageJSON.Config.StdinOnce
+
+	// Entrypoint comparison, an empty entrypoint clears the image one
+	if !strSlicesEqual(containerJSON.Config.Entrypoint, imageJSON.Config.Entrypoint) {
+		service.Entrypoint = append(ComposeCommand{}, containerJSON.Config.Entrypoint...)
+	}
+
+	// Cmd comparison, likewise
+	if !strSlicesEqual(containerJSON.Config.Cmd, imageJSON.Config.Cmd) {
+		service.Cmd = append(ComposeCommand{}, containerJSON.Config.Cmd...)
+	}
+
+	// WorkingDir comparison
+	if containerJSON.Config.WorkingDir != imageJSON.Config.WorkingDir {
+		service.WorkingDir = containerJSON.Config.WorkingDir
+		if service.WorkingDir == "" {
+			// Cleared in the container, the engine then starts in the root
+			service.WorkingDir = "/"
+		}
+	}
+
+	// Hostname comparison: containers get their short ID as hostname, or the
+	// one of the host or container whose network namespace they share
+	hostname := containerJSON.Config.Hostname
+	switch {
+	case hostname == "" || g.opts.KeepHostname:
+	case networkMode.IsContainer():
+		service.note("hostname", "hostname %s omitted, it belongs to %s", hostname, networkMode.ConnectedContainer())
+		hostname = ""
+	case networkMode.IsHost() && hostname == daemonHostname:
+		service.note("hostname", "hostname %s of the host omitted", hostname)
+		hostname = ""
+	case isRandomHostname(hostname, containerJSON.ID):
+		service.note("hostname", "generated hostname %s omitted", hostname)
+		hostname = ""
+	}
+	service.Hostname = hostname
+
+	// Containers created by compose are named after their compose service,
+	// keeping container_name only when compose would not generate it
+	serviceName := containerJSON.Name[1:]
+	if composeService := containerJSON.Config.Labels["com.docker.compose.service"]; composeService != "" && !g.opts.LiteralNames {
+		serviceName = composeService
+		if isDefaultContainerName(service.ContainerName, containerJSON.Config.Labels["com.docker.compose.project"], composeService) {
+			service.ContainerName = ""
+		}
+	}
+
+	// Links are reported as "/source:/receiver/alias"
+	for _, link := range containerJSON.HostConfig.Links {
+		source, target, _ := strings.Cut(link, ":")
+		service.links = append(service.links, linkRef{containerName: strings.TrimPrefix(source, "/"), alias: path.Base(target)})
+	}
+
+	// Compose records the dependencies as service:condition:restart
+	if dependsOn := containerJSON.Config.Labels["com.docker.compose.depends_on"]; dependsOn != "" {
+		for _, dependency := range strings.Split(dependsOn, ",") {
+			parts := strings.Split(dependency, ":")
+			ref := dependencyRef{service: parts[0], condition: "service_started"}
+			if len(parts) > 1 && parts[1] != "" {
+				ref.condition = parts[1]
+			}
+			if len(parts) > 2 {
+				ref.restart = parts[2] == "true"
+			}
+			if ref.service != "" {
+				service.dependsOn = append(service.dependsOn, ref)
+			}
+		}
+	}
+
+	service.containerID = containerJSON.ID
+	service.containerName = containerJSON.Name[1:]
+	service.composeName = containerJSON.Config.Labels["com.docker.compose.service"]
+	service.imageID = imageJSON.ID
+	service.created = containerJSON.Created
+	if len(imageJSON.RepoDigests) > 0 {
+		service.imageDigest = imageJSON.RepoDigests[0]
+	}
+
+	service.escapeDollars()
+	compose.Name = containerJSON.Config.Labels["com.docker.compose.project"]
+	compose.daemonHostname = daemonHostname
+	compose.Services[serviceName] = service
+	return compose
+}
+
+// namespaceRef resolves the container whose key namespace is shared, given by
+// name or by a full or short ID, to its ID and name. A container that cannot
+// be inspected is kept as given, linkServices reporting it as not exported.
+func (g *generator) namespaceRef(key, ref string) namespaceRef {
+	source, err := g.cli.ContainerInspect(g.ctx, ref)
+	if err != nil {
+		g.lookupFailed(err)
+		return namespaceRef{key: key, container: ref}
+	}
+	return namespaceRef{key: key, containerID: source.ID, container: source.Name[1:]}
+}
+
+// linkEntry formats a links entry, leaving out an alias that repeats the name.
+func linkEntry(name, alias string) string {
+	if alias == name {
+		return name
+	}
+	return name + ":" + alias
+}
+
+// linkServices turns references between containers into references between
+// the exported services. Containers referring to one that is not exported keep
+// what they inherited from it.
+func (g *generator) linkServices(compose *ComposeFile) {
+	// visit services in order so that of several replicas of a compose
+	// service, depends_on always names the same one
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	serviceNames := make(map[string]string)
+	composeNames := make(map[string]string)
+	for _, name := range names {
+		service := compose.Services[name]
+		if service.containerID != "" {
+			serviceNames[service.containerID] = name
+			serviceNames[service.containerName] = name
+		}
+		if _, ok := composeNames[service.composeName]; !ok && service.composeName != "" {
+			composeNames[service.composeName] = name
+		}
+	}
+	for _, name := range names {
+		service := compose.Services[name]
+		for _, from := range service.volumesFrom {
+			source, exported := serviceNames[from.containerID]
+			if !exported {
+				g.warn(name, "volumes_from container %s is not exported, copying its volumes instead", from.containerName)
+				service.Volumes = append(service.Volumes, from.volumes...)
+				continue
+			}
+			if from.readOnly {
+				source += ":ro"
+			}
+			service.VolumesFrom = append(service.VolumesFrom, source)
+		}
+		for _, link := range service.links {
+			source, exported := serviceNames[link.containerName]
+			if !exported {
+				service.ExternalLinks = append(service.ExternalLinks, linkEntry(link.containerName, link.alias))
+				continue
+			}
+			service.Links = append(service.Links, linkEntry(source, link.alias))
+			// Links used to imply the start order
+			if _, ok := service.DependsOn[source]; !ok {
+				if service.DependsOn == nil {
+					service.DependsOn = make(ComposeDependsOn)
+				}
+				service.DependsOn[source] = ComposeDependency{Condition: "service_started"}
+			}
+		}
+		for _, namespace := range service.namespaces {
+			source, exported := serviceNames[namespace.containerID]
+			if namespace.containerID == "" {
+				source, exported = serviceNames[namespace.container]
+			}
+			if !exported {
+				g.warn(name, "%s container %s is not exported, keeping the reference to it", namespace.key, namespace.container)
+				service.setNamespace(namespace.key, "container:"+namespace.container)
+				continue
+			}
+			service.setNamespace(namespace.key, "service:"+source)
+		}
+		for _, dependency := range service.dependsOn {
+			target, exported := composeNames[dependency.service]
+			if !exported {
+				g.warn(name, "depends_on service %s is not exported, leaving it out", dependency.service)
+				continue
+			}
+			if service.DependsOn == nil {
+				service.DependsOn = make(ComposeDependsOn)
+			}
+			service.DependsOn[target] = ComposeDependency{Condition: dependency.condition, Restart: dependency.restart}
+		}
+		service.volumesFrom = nil
+		service.links = nil
+		service.dependsOn = nil
+		service.namespaces = nil
+		compose.Services[name] = service
+	}
+}
+
+// bindSource returns the source of a bind mount as a ./ path relative to
+// BindBase when it lies under it, so the project can be moved elsewhere.
+func (g *generator) bindSource(source string) string {
+	if g.opts.BindBase == "" {
+		return source
+	}
+	rel, err := filepath.Rel(g.opts.BindBase, source)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return source
+	}
+	if rel == "." {
+		return "."
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
+// buildHint returns the build section of a locally built image from its OCI
+// source label, or from the compose project directory of the container, or nil
+// when neither is known.
+func buildHint(containerJSON container.InspectResponse, imageJSON image.InspectResponse) *ComposeBuild {
+	if source := imageJSON.Config.Labels["org.opencontainers.image.source"]; source != "" {
+		return &ComposeBuild{Context: source}
+	}
+	if workingDir := containerJSON.Config.Labels["com.docker.compose.project.working_dir"]; workingDir != "" {
+		return &ComposeBuild{Context: workingDir, Dockerfile: "Dockerfile"}
+	}
+	return nil
+}
+
+// isImageID reports whether name is the full or abbreviated ID of the image
+// rather than a reference to it.
+func isImageID(name, imageID string) bool {
+	id := strings.TrimPrefix(name, "sha256:")
+	if len(id) < 12 || strings.Trim(id, "0123456789abcdef") != "" {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimPrefix(imageID, "sha256:"), id)
+}
+
+// imageName returns a tag of the image, or a repo digest if it has no tags or
+// preferDigest is set, and an empty string if it has neither.
+func imageName(imageJSON image.InspectResponse, preferDigest bool) string {
+	if preferDigest && len(imageJSON.RepoDigests) > 0 {
+		return imageJSON.RepoDigests[0]
+	}
+	if len(imageJSON.RepoTags) > 0 {
+		return imageJSON.RepoTags[0]
+	}
+	if len(imageJSON.RepoDigests) > 0 {
+		return imageJSON.RepoDigests[0]
+	}
+	return ""
+}
+
+// pinnedImage returns the digest reference for imageName, preferring a local
+// RepoDigest from the same repository and falling back to asking the registry.
+// Locally built images have no digest and keep their tag with a warning.
+func (g *generator) pinnedImage(imageName string, imageJSON image.InspectResponse) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		g.warn("", "cannot pin image %s: %v", imageName, err)
+		return imageName
+	}
+
+	for _, repoDigest := range imageJSON.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if _, ok := digested.(reference.Digested); ok && digested.Name() == named.Name() {
+			return reference.FamiliarString(digested)
+		}
+	}
+
+	distribution, err := g.cli.DistributionInspect(g.ctx, imageName, "")
+	if err == nil {
+		if digested, err := reference.WithDigest(reference.TrimNamed(named), distribution.Descriptor.Digest); err == nil {
+			return reference.FamiliarString(digested)
+		}
+	}
+
+	g.warn("", "no repo digest found for image %s, keeping the tag", imageName)
+	return imageName
+}
+
+// assignedPort returns the host port the engine assigned to a binding of
+// containerPort to hostIP that asked for none, or "" when the container is
+// not running.
+func assignedPort(containerJSON container.InspectResponse, containerPort nat.Port, hostIP string) string {
+	if containerJSON.NetworkSettings == nil {
+		return ""
+	}
+	for _, binding := range containerJSON.NetworkSettings.Ports[containerPort] {
+		// A binding to every address is reported once per address family
+		if binding.HostIP == hostIP || (hostIP == "" && (binding.HostIP == "0.0.0.0" || binding.HostIP == "::")) {
+			return binding.HostPort
+		}
+	}
+	return ""
+}
+
+// portMapping is a published port, or a run of count consecutive ports
+// starting at hostPort and containerPort.
+type portMapping struct {
+	hostIP        string
+	hostPort      string
+	containerPort int
+	proto         string
+	count         int
+}
+
+func (m portMapping) String() string {
+	containerPort := portRange(m.containerPort, m.count)
+	hostIP := m.hostIP
+	if strings.Contains(hostIP, ":") {
+		// IPv6 addresses are bracketed to set them apart from the ports
+		hostIP = "[" + hostIP + "]"
+	}
+	if m.hostPort == "" {
+		if hostIP != "" {
+			return hostIP + "::" + containerPort + m.protoSuffix()
+		}
+		return containerPort + m.protoSuffix()
+	}
+	// A host port range for a single container port ("8000-8010:80") is
+	// emitted as given, collapsePortRanges never extends it
+	hostPortRange := m.hostPort
+	if hostPort, err := strconv.Atoi(m.hostPort); err == nil {
+		hostPortRange = portRange(hostPort, m.count)
+	}
+	mapping := hostPortRange + ":" + containerPort
+	if hostIP != "" {
+		mapping = hostIP + ":" + mapping
+	}
+	return mapping + m.protoSuffix()
+}
+
+// isWildcardIP reports whether a binding to ip listens on every address, of
+// either family.
+func isWildcardIP(ip string) bool {
+	return ip == "" || ip == "0.0.0.0" || ip == "::"
+}
+
+// protoSuffix returns the "/udp" or "/sctp" suffix; tcp is the compose default.
+func (m portMapping) protoSuffix() string {
+	if m.proto == "" || m.proto == "tcp" {
+		return ""
+	}
+	return "/" + m.proto
+}
+
+func portRange(start, count int) string {
+	if count <= 1 {
+		return strconv.Itoa(start)
+	}
+	return strconv.Itoa(start) + "-" + strconv.Itoa(start+count-1)
+}
+
+func sortPortMappings(mappings []portMapping) {
+	sort.Slice(mappings, func(i, j int) bool {
+		a, b := mappings[i], mappings[j]
+		if a.proto != b.proto {
+			return a.proto < b.proto
+		}
+		if a.hostIP != b.hostIP {
+			return a.hostIP < b.hostIP
+		}
+		if a.containerPort != b.containerPort {
+			return a.containerPort < b.containerPort
+		}
+		return a.hostPort < b.hostPort
+	})
+}
+
+// collapsePortRanges merges sorted mappings into "8000-8200:8000-8200" style
+// ranges where the protocol and host IP match and the host and container ports
+// both advance in lockstep.
+func collapsePortRanges(mappings []portMapping) []portMapping {
+	var collapsed []portMapping
+	for _, mapping := range mappings {
+		if len(collapsed) > 0 {
+			last := &collapsed[len(collapsed)-1]
+			lastHost, lastErr := strconv.Atoi(last.hostPort)
+			host, err := strconv.Atoi(mapping.hostPort)
+			if lastErr == nil && err == nil && last.proto == mapping.proto && last.hostIP == mapping.hostIP &&
+				mapping.containerPort == last.containerPort+last.count && host == lastHost+last.count {
+				last.count++
+				continue
+			}
+		}
+		collapsed = append(collapsed, mapping)
+	}
+	return collapsed
+}
+
+// serviceNetwork returns the per-service settings of a network endpoint, or nil
+// when it has none and the network can be listed by name only. Aliases in
+// engineAliases were added by the engine or compose and are left out.
+func serviceNetwork(endpoint *network.EndpointSettings, engineAliases map[string]bool) *ComposeServiceNetwork {
+	if endpoint == nil {
+		return nil
+	}
+	settings := &ComposeServiceNetwork{}
+	if endpoint.IPAMConfig != nil {
+		settings.Ipv4Address = endpoint.IPAMConfig.IPv4Address
+		settings.Ipv6Address = endpoint.IPAMConfig.IPv6Address
+	}
+	for _, alias := range endpoint.Aliases {
+		if !engineAliases[alias] {
+			settings.Aliases = append(settings.Aliases, alias)
+		}
+	}
+	if settings.Ipv4Address == "" && settings.Ipv6Address == "" && len(settings.Aliases) == 0 {
+		return nil
+	}
+	return settings
+}
+
+// normalizeArch maps the uname-style architecture reported by the daemon to
+// the GOARCH-style name used in image metadata.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "armv7l", "armv6l", "armhf", "armel":
+		return "arm"
+	case "i386", "i686":
+		return "386"
+	}
+	return arch
+}
+
+// deviceRequest converts a HostConfig device request into the compose
+// deploy.resources.reservations.devices entry. A count of -1 means all devices.
+func deviceRequest(request container.DeviceRequest) ComposeDeviceRequest {
+	device := ComposeDeviceRequest{
+		Driver:    request.Driver,
+		DeviceIDs: request.DeviceIDs,
+		Options:   request.Options,
+	}
+	if request.Count < 0 {
+		device.Count = "all"
+	} else if request.Count > 0 {
+		device.Count = request.Count
+	}
+	// Compose only has a flat capability list, so merge the OR-ed groups
+	seen := make(map[string]bool)
+	for _, group := range request.Capabilities {
+		for _, capability := range group {
+			if !seen[capability] {
+				seen[capability] = true
+				device.Capabilities = append(device.Capabilities, capability)
+			}
+		}
+	}
+	return device
+}
+
+// volumeOptions returns the ":ro,rshared" style suffix of a short-syntax volume
+// entry.
+func volumeOptions(m container.MountPoint) string {
+	var options []string
+	if !m.RW {
+		options = append(options, "ro")
+	}
+	// rprivate is the default propagation for bind mounts
+	if m.Type == mount.TypeBind && m.Propagation != "" && m.Propagation != mount.PropagationRPrivate {
+		options = append(options, string(m.Propagation))
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	return ":" + strings.Join(options, ",")
+}
+
+// findHostMount returns the HostConfig mount for target, which carries the
+// options that are missing from the inspected mount point.
+func findHostMount(target string, mounts []mount.Mount) *mount.Mount {
+	for i := range mounts {
+		if mounts[i].Target == target {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
+
+// serviceVolume builds the volumes entry for a mount, in the long syntax if
+// requested or required and the short "source:target:options" syntax otherwise.
+func serviceVolume(source string, mountPoint container.MountPoint, hostMount *mount.Mount, long bool) ComposeServiceVolume {
+	// A volume subpath can only be expressed in the long syntax
+	subpath := ""
+	if mountPoint.Type == mount.TypeVolume && hostMount != nil && hostMount.VolumeOptions != nil {
+		subpath = hostMount.VolumeOptions.Subpath
+	}
+	if !long && subpath == "" {
+		return ComposeServiceVolume{Short: source + ":" + mountPoint.Destination + volumeOptions(mountPoint)}
+	}
+
+	volumeMount := &ComposeVolumeMount{
+		Type:     string(mountPoint.Type),
+		Source:   source,
+		Target:   mountPoint.Destination,
+		ReadOnly: !mountPoint.RW,
+	}
+	switch mountPoint.Type {
+	case mount.TypeBind:
+		if mountPoint.Propagation != "" && mountPoint.Propagation != mount.PropagationRPrivate {
+			volumeMount.Bind = &ComposeBindOptions{Propagation: string(mountPoint.Propagation)}
+		}
+	case mount.TypeVolume:
+		if hostMount != nil && hostMount.VolumeOptions != nil && (hostMount.VolumeOptions.NoCopy || subpath != "") {
+			volumeMount.Volume = &ComposeVolumeOptions{NoCopy: hostMount.VolumeOptions.NoCopy, Subpath: subpath}
+		}
+	case mount.TypeTmpfs:
+		volumeMount.ReadOnly = false
+		if hostMount != nil && hostMount.TmpfsOptions != nil {
+			size, mode := tmpfsOptions(hostMount.TmpfsOptions)
+			if size != "" || mode != "" {
+				volumeMount.Tmpfs = &ComposeTmpfsOptions{Size: size, Mode: mode}
+			}
+		}
+	}
+	return ComposeServiceVolume{Long: volumeMount}
+}
+
+// tmpfsMapping builds the "path:size=...,mode=..." entry for a tmpfs mount,
+// taking the size and mode from the matching HostConfig mount if there is one.
+func tmpfsMapping(target string, hostMount *mount.Mount) string {
+	if hostMount == nil || hostMount.TmpfsOptions == nil {
+		return target
+	}
+	var options []string
+	size, mode := tmpfsOptions(hostMount.TmpfsOptions)
+	if size != "" {
+		options = append(options, "size="+size)
+	}
+	if mode != "" {
+		options = append(options, "mode="+mode)
+	}
+	if len(options) == 0 {
+		return target
+	}
+	return target + ":" + strings.Join(options, ",")
+}
+
+// tmpfsOptions formats the size and octal mode of a tmpfs mount, leaving
+// unset values empty.
+func tmpfsOptions(options *mount.TmpfsOptions) (size, mode string) {
+	if options.SizeBytes > 0 {
+		size = formatBytes(options.SizeBytes)
+	}
+	if options.Mode != 0 {
+		mode = fmt.Sprintf("%o", options.Mode)
+	}
+	return size, mode
+}
+
+func healthchecksEqual(a, b *container.HealthConfig) bool {
+	if len(a.Test) != len(b.Test) || a.Interval != b.Interval || a.Timeout != b.Timeout || a.Retries != b.Retries ||
+		a.StartPeriod != b.StartPeriod || a.StartInterval != b.StartInterval {
+		return false
+	}
+	for i, v := range a.Test {
+		if v != b.Test[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func composeHealthcheck(h *container.HealthConfig) *ComposeHealthcheck {
+	if healthcheckDisabled(h) {
+		return &ComposeHealthcheck{Disable: true}
+	}
+	return &ComposeHealthcheck{
+		Test:          h.Test,
+		Interval:      h.Interval,
+		Timeout:       h.Timeout,
+		Retries:       h.Retries,
+		StartPeriod:   h.StartPeriod,
+		StartInterval: h.StartInterval,
+	}
+}
+
+// healthcheckDisabled reports whether h turns off the image healthcheck, which
+// the engine records as the single test "NONE".
+func healthcheckDisabled(h *container.HealthConfig) bool {
+	return h != nil && len(h.Test) > 0 && h.Test[0] == "NONE"
+}
+
+func isComposeVolume(volumeInspect volume.Volume) bool {
+	for key := range volumeInspect.Labels {
+		if strings.HasPrefix(key, "com.docker.compose.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isAnonymousVolume reports whether a volume was created without a name, which
+// recent engines label and older ones give a random 64 character hex name.
+func isAnonymousVolume(name string, volumeInspect volume.Volume) bool {
+	if _, ok := volumeInspect.Labels["com.docker.volume.anonymous"]; ok {
+		return true
+	}
+	if len(name) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+func isBuiltInNetwork(networkName string) bool {
+	return networkName == "bridge" || networkName == "host" || networkName == "none"
+}
+
+func isComposeNetwork(networkInspect network.Inspect) bool {
+	for key := range networkInspect.Labels {
+		if strings.HasPrefix(key, "com.docker.compose.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isDefaultContainerName reports whether name is what compose would generate for
+// the service anyway: project-service-N, or project_service_N with compose v1.
+func isDefaultContainerName(name, project, service string) bool {
+	for _, sep := range []string{"-", "_"} {
+		prefix := project + sep + service + sep
+		if strings.HasPrefix(name, prefix) {
+			if _, err := strconv.Atoi(name[len(prefix):]); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRandomHostname reports whether hostname is the short ID the engine gives
+// containers by default.
+func isRandomHostname(hostname, containerID string) bool {
+	return len(containerID) >= 12 && hostname == containerID[:12]
+}
+
+func strSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if v != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// formatDuration formats d for compose, dropping trailing zero units so that
+// an hour comes out as "1h" rather than "1h0m0s". Zero yields an empty string.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	str := d.String()
+	if strings.HasSuffix(str, "m0s") {
+		str = str[:len(str)-2]
+	}
+	if strings.HasSuffix(str, "h0m") {
+		str = str[:len(str)-2]
+	}
+	return str
+}
+
+// formatBytes renders a byte count in the unit notation compose accepts for
+// memory values, using the largest unit that divides it evenly.
+func formatBytes(n int64) string {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"g", 1 << 30},
+		{"m", 1 << 20},
+		{"k", 1 << 10},
+	}
+	for _, u := range units {
+		if n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "b"
+}
+
+// formatRate renders a bytes-per-second rate like "10mb", the notation the
+// docker CLI accepts for device throttles.
+func formatRate(rate uint64) string {
+	str := formatBytes(int64(rate))
+	if !strings.HasSuffix(str, "b") {
+		str += "b"
+	}
+	return str
+}
+
+// escapeDollars doubles the $ in the environment, labels, command and
+// healthcheck test, which compose would otherwise interpolate, so that they
+// read back as the container had them.
+func (s *ComposeService) escapeDollars() {
+	escapeMap := func(m StringMap) {
+		for key, value := range m {
+			m[key] = strings.ReplaceAll(value, "$", "$$")
+		}
+	}
+	escapeSlice := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		escaped := make([]string, len(values))
+		for i, value := range values {
+			escaped[i] = strings.ReplaceAll(value, "$", "$$")
+		}
+		return escaped
+	}
+	escapeMap(s.Environment)
+	escapeMap(s.Labels)
+	s.Entrypoint = escapeSlice(s.Entrypoint)
+	s.Cmd = escapeSlice(s.Cmd)
+	if s.Healthcheck != nil {
+		healthcheck := *s.Healthcheck
+		healthcheck.Test = escapeSlice(healthcheck.Test)
+		s.Healthcheck = &healthcheck
+	}
+	if s.Deploy != nil {
+		escapeMap(s.Deploy.Labels)
+	}
+}
+
+func parseEnv(envVars []string) map[string]string {
+	envMap := make(map[string]string)
+	for _, env := range envVars {
+		parts := stringParts(env, "=")
+		if len(parts) == 2 {
+			envMap[parts[0]] = parts[1]
+		}
+	}
+	return envMap
+}
+
+func stringParts(s, sep string) []string {
+	idx := -1
+	for i := 0; i < len(s); i++ {
+		if string(s[i]) == sep {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return []string{s}
+	}
+	return []string{s[:idx], s[idx+1:]}
+}
@@ -0,0 +1,622 @@
+package autocompose
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+)
+
+// generateFixture exports the named containers from an InspectClient loaded
+// with the given inspect objects, failing the test on errors.
+func generateFixture(t *testing.T, cli *InspectClient, names []string, opts Options) (ComposeFile, []Warning) {
+	t.Helper()
+	compose, warnings, err := Generate(context.Background(), cli, names, opts)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	return compose, warnings
+}
+
+// inspectClient returns an InspectClient holding the given inspect objects.
+func inspectClient(t *testing.T, objects ...interface{}) *InspectClient {
+	t.Helper()
+	data, err := json.Marshal(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &InspectClient{}
+	if err := cli.Add(data); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	return cli
+}
+
+// testContainer returns a running container named name created from the
+// image testImage returns, to be adjusted by the test.
+func testContainer(name string) container.InspectResponse {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         "0123456789ab" + name,
+			Name:       "/" + name,
+			Image:      "sha256:test",
+			State:      &container.State{Status: "running", Running: true},
+			HostConfig: &container.HostConfig{NetworkMode: "default"},
+		},
+		Config:          &container.Config{Image: "test:latest", Labels: map[string]string{}},
+		NetworkSettings: &container.NetworkSettings{},
+	}
+}
+
+func testImage() image.InspectResponse {
+	return image.InspectResponse{ID: "sha256:test", RepoTags: []string{"test:latest"}, Config: &container.Config{}}
+}
+
+func TestGenerateFixture(t *testing.T) {
+	cli, err := NewInspectClient("testdata/shop.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compose, warnings := generateFixture(t, cli, []string{"shop-web-1", "shop-db-1"}, Options{})
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	got, err := compose.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `name: shop
+services:
+    db:
+        image: postgres:16
+        volumes:
+            - shop_data:/var/lib/postgresql/data
+        environment:
+            POSTGRES_PASSWORD: secret
+        restart: unless-stopped
+    web:
+        image: nginx:1.27
+        ports:
+            - 8080:80
+        volumes:
+            - /srv/shop/html:/usr/share/nginx/html:ro
+        environment:
+            MODE: prod
+        restart: unless-stopped
+        depends_on:
+            db:
+                condition: service_healthy
+volumes:
+    shop_data:
+        name: shop_data
+`
+	if string(got) != want {
+		t.Errorf("Generate output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPortMappingString(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping portMapping
+		want    string
+	}{
+		{"single port", portMapping{hostPort: "8080", containerPort: 80, count: 1}, "8080:80"},
+		{"range", portMapping{hostPort: "8000", containerPort: 8000, count: 11}, "8000-8010:8000-8010"},
+		{"host range on one port", portMapping{hostPort: "8000-8010", containerPort: 80, count: 1}, "8000-8010:80"},
+		{"no host port", portMapping{containerPort: 80, count: 1}, "80"},
+		{"udp", portMapping{hostPort: "53", containerPort: 53, proto: "udp", count: 1}, "53:53/udp"},
+		{"IPv4 address", portMapping{hostIP: "127.0.0.1", hostPort: "8080", containerPort: 80, count: 1}, "127.0.0.1:8080:80"},
+		{"IPv6 loopback", portMapping{hostIP: "::1", hostPort: "8080", containerPort: 80, count: 1}, "[::1]:8080:80"},
+		{"IPv6 global address", portMapping{hostIP: "2001:db8::10", hostPort: "443", containerPort: 443, count: 1}, "[2001:db8::10]:443:443"},
+		{"IPv6 without host port", portMapping{hostIP: "::1", containerPort: 80, count: 1}, "[::1]::80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mapping.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWildcardIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"::1", false},
+		{"127.0.0.1", false},
+		{"2001:db8::10", false},
+	}
+	for _, tt := range tests {
+		if got := isWildcardIP(tt.ip); got != tt.want {
+			t.Errorf("isWildcardIP(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateWildcardBindings(t *testing.T) {
+	web := testContainer("web")
+	web.HostConfig.PortBindings = nat.PortMap{"80/tcp": {
+		{HostIP: "0.0.0.0", HostPort: "8080"},
+		{HostIP: "::", HostPort: "8080"},
+		{HostIP: "::1", HostPort: "8081"},
+	}}
+	service, _ := serviceOf(t, web, Options{})
+	var got []string
+	for _, port := range service.Ports {
+		got = append(got, port.Short)
+	}
+	want := []string{"8080:80", "[::1]:8081:80"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ports = %v, want %v", got, want)
+	}
+}
+
+func TestCollapsePortRangesKeepsHostRanges(t *testing.T) {
+	mappings := []portMapping{
+		{hostPort: "8000-8010", containerPort: 80, count: 1},
+		{hostPort: "8011", containerPort: 81, count: 1},
+	}
+	collapsed := collapsePortRanges(mappings)
+	if len(collapsed) != 2 {
+		t.Fatalf("collapsePortRanges merged a host port range: %v", collapsed)
+	}
+}
+
+// serviceOf exports a single container and returns its service.
+func serviceOf(t *testing.T, containerJSON container.InspectResponse, opts Options, objects ...interface{}) (ComposeService, []Warning) {
+	t.Helper()
+	cli := inspectClient(t, append([]interface{}{containerJSON, testImage()}, objects...)...)
+	compose, warnings := generateFixture(t, cli, []string{containerJSON.ID}, opts)
+	for _, service := range compose.Services {
+		return service, warnings
+	}
+	t.Fatal("no service exported")
+	return ComposeService{}, nil
+}
+
+func TestGenerateHostPortRange(t *testing.T) {
+	web := testContainer("web")
+	web.HostConfig.PortBindings = nat.PortMap{"80/tcp": {{HostPort: "8000-8010"}}}
+	service, _ := serviceOf(t, web, Options{})
+	if len(service.Ports) != 1 || service.Ports[0].Short != "8000-8010:80" {
+		t.Errorf("ports = %+v, want 8000-8010:80", service.Ports)
+	}
+}
+
+func TestGenerateComposeDefaultNetwork(t *testing.T) {
+	defaultNetwork := network.Inspect{Name: "shop_default", ID: "net1", Labels: map[string]string{"com.docker.compose.project": "shop", "com.docker.compose.network": "default"}}
+	backend := network.Inspect{Name: "backend", ID: "net2"}
+	tests := []struct {
+		name     string
+		networks []string
+		want     []string
+	}{
+		{"only default", []string{"shop_default"}, nil},
+		{"default and another", []string{"shop_default", "backend"}, []string{"backend", "default"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := testContainer("web")
+			web.NetworkSettings.Networks = make(map[string]*network.EndpointSettings)
+			for _, name := range tt.networks {
+				web.NetworkSettings.Networks[name] = &network.EndpointSettings{}
+			}
+			service, _ := serviceOf(t, web, Options{}, defaultNetwork, backend)
+			var got []string
+			for name := range service.Networks {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("networks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratePortProtocols(t *testing.T) {
+	web := testContainer("web")
+	web.HostConfig.PortBindings = nat.PortMap{
+		"80/tcp":    {{HostPort: "8080"}},
+		"53/udp":    {{HostIP: "127.0.0.1", HostPort: "5353"}},
+		"9899/sctp": {{HostPort: "9899"}},
+	}
+	service, _ := serviceOf(t, web, Options{})
+	var got []string
+	for _, port := range service.Ports {
+		got = append(got, port.Short)
+	}
+	want := []string{"9899:9899/sctp", "8080:80", "127.0.0.1:5353:53/udp"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ports = %v, want %v", got, want)
+	}
+}
+
+// loadProject parses compose file data the way docker compose config does.
+func loadProject(t *testing.T, data []byte) *types.Project {
+	t.Helper()
+	project, err := loader.LoadWithContext(context.Background(), types.ConfigDetails{
+		WorkingDir:  t.TempDir(),
+		ConfigFiles: []types.ConfigFile{{Filename: "compose.yml", Content: data}},
+		Environment: types.Mapping{},
+	}, func(o *loader.Options) {
+		o.SetProjectName("test", false)
+	})
+	if err != nil {
+		t.Fatalf("compose rejects the file: %v\n%s", err, data)
+	}
+	return project
+}
+
+func TestGenerateStdinOpen(t *testing.T) {
+	for _, interactive := range []bool{true, false} {
+		web := testContainer("web")
+		web.Config.OpenStdin = interactive
+		cli := inspectClient(t, web, testImage())
+		compose, _ := generateFixture(t, cli, []string{web.ID}, Options{})
+		data, err := compose.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(data), "stdin_open: true"); got != interactive {
+			t.Errorf("-i %v: stdin_open emitted = %v\n%s", interactive, got, data)
+		}
+		service, err := loadProject(t, data).GetService("web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if service.StdinOpen != interactive {
+			t.Errorf("-i %v: compose reads stdin_open %v", interactive, service.StdinOpen)
+		}
+	}
+}
+
+func TestGenerateUlimits(t *testing.T) {
+	web := testContainer("web")
+	web.HostConfig.Ulimits = []*container.Ulimit{
+		{Name: "nofile", Soft: 65536, Hard: 65536},
+		{Name: "nproc", Soft: 4096, Hard: 8192},
+		{Name: "memlock", Soft: -1, Hard: -1},
+	}
+	service, _ := serviceOf(t, web, Options{})
+	data, err := yaml.Marshal(service.Ulimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `memlock: -1
+nofile: 65536
+nproc:
+    soft: 4096
+    hard: 8192
+`
+	if string(data) != want {
+		t.Errorf("ulimits:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestServiceNetworkAliases(t *testing.T) {
+	engineAliases := map[string]bool{"web": true, "0123456789ab": true}
+	tests := []struct {
+		name     string
+		endpoint *network.EndpointSettings
+		want     *ComposeServiceNetwork
+	}{
+		{"no endpoint", nil, nil},
+		{"engine aliases only", &network.EndpointSettings{Aliases: []string{"web", "0123456789ab"}}, nil},
+		{"user alias", &network.EndpointSettings{Aliases: []string{"web", "db-primary", "0123456789ab"}}, &ComposeServiceNetwork{Aliases: []string{"db-primary"}}},
+		{"static address", &network.EndpointSettings{IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "10.0.0.5"}}, &ComposeServiceNetwork{Ipv4Address: "10.0.0.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serviceNetwork(tt.endpoint, engineAliases)
+			if (got == nil) != (tt.want == nil) || (got != nil && (got.Ipv4Address != tt.want.Ipv4Address || !slices.Equal(got.Aliases, tt.want.Aliases))) {
+				t.Errorf("serviceNetwork() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateNetworkAliases(t *testing.T) {
+	web := testContainer("web")
+	web.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+		"backend": {Aliases: []string{"web", web.ID[:12], "db-primary"}},
+	}
+	service, _ := serviceOf(t, web, Options{}, network.Inspect{Name: "backend", ID: "net2"})
+	settings := service.Networks["backend"]
+	if settings == nil || !slices.Equal(settings.Aliases, []string{"db-primary"}) {
+		t.Errorf("backend settings = %+v, want only the db-primary alias", settings)
+	}
+}
+
+func TestGenerateCpus(t *testing.T) {
+	tests := []struct {
+		name                    string
+		nanoCPUs, quota, period int64
+		want                    string
+	}{
+		{"--cpus", 1500000000, 0, 0, "1.50"},
+		{"quota and period", 0, 50000, 200000, "0.25"},
+		{"quota with default period", 0, 50000, 0, "0.50"},
+		{"unlimited", 0, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := testContainer("web")
+			web.HostConfig.NanoCPUs = tt.nanoCPUs
+			web.HostConfig.CPUQuota = tt.quota
+			web.HostConfig.CPUPeriod = tt.period
+			service, _ := serviceOf(t, web, Options{})
+			if service.Cpus != tt.want {
+				t.Errorf("cpus = %q, want %q", service.Cpus, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateGPUMemoryReservation(t *testing.T) {
+	web := testContainer("web")
+	web.HostConfig.DeviceRequests = []container.DeviceRequest{{Driver: "nvidia", Count: -1, Capabilities: [][]string{{"gpu"}}}}
+	web.HostConfig.MemoryReservation = 512 * 1024 * 1024
+	cli := inspectClient(t, web, testImage())
+	compose, _ := generateFixture(t, cli, []string{web.ID}, Options{})
+	data, err := compose.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := loadProject(t, data).GetService("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservations := service.Deploy.Resources.Reservations
+	if service.MemReservation != 0 || reservations == nil || reservations.MemoryBytes != 512*1024*1024 || len(reservations.Devices) != 1 {
+		t.Errorf("mem_reservation = %d, reservations = %+v, want the memory among the reservations\n%s", service.MemReservation, reservations, data)
+	}
+}
+
+// daemonClient answers the daemon info with info.
+type daemonClient struct {
+	*InspectClient
+	info system.Info
+}
+
+func (c daemonClient) Info(ctx context.Context) (system.Info, error) {
+	return c.info, nil
+}
+
+func TestGenerateHostname(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostname    string
+		networkMode container.NetworkMode
+		keep        bool
+		want        string
+	}{
+		{"generated", "0123456789ab", "default", false, ""},
+		{"set by the user", "web01", "default", false, "web01"},
+		{"host network", "dockerhost", "host", false, ""},
+		{"host network, other name", "web01", "host", false, "web01"},
+		{"shared network namespace", "vpn", "container:vpn", false, ""},
+		{"--keep-hostname", "dockerhost", "host", true, "dockerhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := testContainer("web")
+			web.Config.Hostname = tt.hostname
+			web.HostConfig.NetworkMode = tt.networkMode
+			cli := daemonClient{inspectClient(t, web, testImage()), system.Info{Name: "dockerhost"}}
+			compose, _, err := Generate(context.Background(), cli, []string{web.ID}, Options{KeepHostname: tt.keep})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := compose.Services["web"].Hostname; got != tt.want {
+				t.Errorf("hostname = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateWorkingDir(t *testing.T) {
+	tests := []struct {
+		name             string
+		image, container string
+		want             string
+	}{
+		{"unset", "", "", ""},
+		{"inherited", "/app", "/app", ""},
+		{"overridden", "/app", "/srv", "/srv"},
+		{"cleared", "/app", "", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := testContainer("web")
+			web.Config.WorkingDir = tt.container
+			imageJSON := testImage()
+			imageJSON.Config.WorkingDir = tt.image
+			cli := inspectClient(t, web, imageJSON)
+			compose, _ := generateFixture(t, cli, []string{web.ID}, Options{})
+			if got := compose.Services["web"].WorkingDir; got != tt.want {
+				t.Errorf("working_dir = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateEnvironment(t *testing.T) {
+	tests := []struct {
+		name             string
+		image, container []string
+		want             StringMap
+		notes            []string
+	}{
+		{"inherited", []string{"PATH=/bin"}, []string{"PATH=/bin"}, StringMap{}, []string{"environment: 1 variables inherited from the image omitted"}},
+		{"overridden", []string{"PATH=/bin"}, []string{"PATH=/usr/bin"}, StringMap{"PATH": "/usr/bin"}, nil},
+		{"empty, not in the image", nil, []string{"FOO="}, StringMap{"FOO": ""}, nil},
+		{"empty, cleared", []string{"FOO=bar"}, []string{"FOO="}, StringMap{"FOO": ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := testContainer("web")
+			web.Config.Env = tt.container
+			imageJSON := testImage()
+			imageJSON.Config.Env = tt.image
+			cli := inspectClient(t, web, imageJSON)
+			compose, _ := generateFixture(t, cli, []string{web.ID}, Options{})
+			service := compose.Services["web"]
+			if !maps.Equal(service.Environment, tt.want) {
+				t.Errorf("environment = %v, want %v", service.Environment, tt.want)
+			}
+			if notes := service.Notes(); !slices.Equal(notes, tt.notes) {
+				t.Errorf("notes = %q, want %q", notes, tt.notes)
+			}
+		})
+	}
+}
+
+func TestGenerateEscapesDollars(t *testing.T) {
+	web := testContainer("web")
+	web.Config.Env = []string{"PRICE=$5", "GREETING=${USER:-you}", "ESCAPED=$$"}
+	web.Config.Labels = map[string]string{"cost": "$5"}
+	web.Config.Cmd = []string{"sh", "-c", "echo $HOME"}
+	web.Config.Healthcheck = &container.HealthConfig{Test: []string{"CMD-SHELL", "test -n \"$HOSTNAME\""}}
+	cli := inspectClient(t, web, testImage())
+	compose, _ := generateFixture(t, cli, []string{web.ID}, Options{})
+	data, err := compose.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := loadProject(t, data).GetService("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"PRICE": "$5", "GREETING": "${USER:-you}", "ESCAPED": "$$"} {
+		if got := service.Environment[key]; got == nil || *got != want {
+			t.Errorf("environment %s does not read back as %q\n%s", key, want, data)
+		}
+	}
+	if got := service.Labels["cost"]; got != "$5" {
+		t.Errorf("label cost reads back as %q, want $5", got)
+	}
+	if got := []string(service.Command); !slices.Equal(got, web.Config.Cmd) {
+		t.Errorf("command reads back as %q, want %q", got, web.Config.Cmd)
+	}
+	if got := service.HealthCheck.Test; !slices.Equal(got, web.Config.Healthcheck.Test) {
+		t.Errorf("healthcheck test reads back as %q, want %q", got, web.Config.Healthcheck.Test)
+	}
+}
+
+func TestGenerateNetworkDisabled(t *testing.T) {
+	web := testContainer("web")
+	web.Config.NetworkDisabled = true
+	cli := inspectClient(t, web, testImage())
+	compose, _ := generateFixture(t, cli, []string{web.ID}, Options{})
+	data, err := compose.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := loadProject(t, data).GetService("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.NetworkMode != "none" || len(service.Networks) != 0 {
+		t.Errorf("network_mode = %q, networks = %v, want none and no networks\n%s", service.NetworkMode, service.Networks, data)
+	}
+}
+
+func TestGenerateDependsOnReplicas(t *testing.T) {
+	var containers []interface{}
+	var names []string
+	for _, name := range []string{"shop-web-1", "shop-web-2", "shop-web-3", "shop-app-1"} {
+		c := testContainer(name)
+		c.Config.Labels["com.docker.compose.project"] = "shop"
+		c.Config.Labels["com.docker.compose.service"] = strings.Split(name, "-")[1]
+		if name == "shop-app-1" {
+			c.Config.Labels["com.docker.compose.depends_on"] = "web:service_started:false"
+		}
+		containers = append(containers, c)
+		names = append(names, c.ID)
+	}
+	cli := inspectClient(t, append(containers, testImage())...)
+	for i := 0; i < 20; i++ {
+		compose, _ := generateFixture(t, cli, names, Options{})
+		if _, ok := compose.Services["app"].DependsOn["web"]; !ok || len(compose.Services["app"].DependsOn) != 1 {
+			t.Fatalf("depends_on = %v, want web", compose.Services["app"].DependsOn)
+		}
+	}
+}
+
+func TestGenerateNamespaceShortID(t *testing.T) {
+	vpn := testContainer("vpn")
+	vpn.ID = "fedcba9876543210"
+	web := testContainer("web")
+	web.HostConfig.NetworkMode = "container:fedcba987654"
+	web.HostConfig.IpcMode = "container:vpn"
+	cli := inspectClient(t, web, vpn, testImage())
+
+	compose, warnings := generateFixture(t, cli, []string{web.ID, vpn.ID}, Options{})
+	if got := compose.Services["web"]; got.NetworkMode != "service:vpn" || got.Ipc != "service:vpn" {
+		t.Errorf("network_mode = %q, ipc = %q, want service:vpn", got.NetworkMode, got.Ipc)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	compose, warnings = generateFixture(t, cli, []string{web.ID}, Options{})
+	if got := compose.Services["web"].NetworkMode; got != "container:vpn" {
+		t.Errorf("network_mode = %q, want container:vpn", got)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("warnings = %v, want network_mode and ipc not exported", warnings)
+	}
+}
+
+func TestGenerateServicesStack(t *testing.T) {
+	web := swarm.Service{ID: "svc1", Spec: swarm.ServiceSpec{
+		Annotations: swarm.Annotations{Name: "shop_web", Labels: map[string]string{"com.docker.stack.namespace": "shop"}},
+		TaskTemplate: swarm.TaskSpec{
+			ContainerSpec: &swarm.ContainerSpec{Image: "nginx:1.27", Hosts: []string{"10.0.0.9 nas", "10.0.0.5 db"}},
+			Networks:      []swarm.NetworkAttachmentConfig{{Target: "net1", Aliases: []string{"web"}}, {Target: "net2"}},
+		},
+	}}
+	stackNetwork := network.Inspect{Name: "shop_backend", ID: "net1"}
+	otherNetwork := network.Inspect{Name: "proxy", ID: "net2"}
+	cli := inspectClient(t, web, stackNetwork, otherNetwork)
+	compose, _, err := GenerateServices(context.Background(), cli, []string{"shop_web"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := compose.Services["web"]
+	if want := []string{"db:10.0.0.5", "nas:10.0.0.9"}; !slices.Equal(service.ExtraHosts, want) {
+		t.Errorf("extra_hosts = %q, want %q", service.ExtraHosts, want)
+	}
+	want := map[string]ComposeNetwork{"backend": {}, "proxy": {External: true, Name: "proxy"}}
+	if !maps.Equal(compose.Networks, want) {
+		t.Errorf("networks = %+v, want %+v", compose.Networks, want)
+	}
+	if _, ok := service.Networks["backend"]; !ok {
+		t.Errorf("service networks = %v, want backend", slices.Sorted(maps.Keys(service.Networks)))
+	}
+	data, err := compose.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadProject(t, data)
+}
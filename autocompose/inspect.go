@@ -0,0 +1,210 @@
+package autocompose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// InspectClient is a Client answering from saved "docker inspect" output
+// instead of a daemon, so containers can be exported offline or from fixtures.
+// Objects it does not hold are reported as not found, and the daemon info and
+// version are empty.
+type InspectClient struct {
+	containers []container.InspectResponse
+	images     []image.InspectResponse
+	volumes    []volume.Volume
+	networks   []network.Inspect
+	services   []swarm.Service
+}
+
+var _ Client = (*InspectClient)(nil)
+
+// NewInspectClient loads the JSON arrays printed by docker inspect (of
+// containers, images, volumes, networks or services, in any mix) from the
+// given files.
+func NewInspectClient(paths ...string) (*InspectClient, error) {
+	c := &InspectClient{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s: %v", path, err)
+		}
+		if err := c.Add(data); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+		}
+	}
+	return c, nil
+}
+
+// Add loads a JSON array of inspect objects, telling them apart by the fields
+// only each kind has.
+func (c *InspectClient) Add(data []byte) error {
+	var objects []json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return err
+	}
+	for _, object := range objects {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(object, &fields); err != nil {
+			return err
+		}
+		has := func(key string) bool {
+			_, ok := fields[key]
+			return ok
+		}
+
+		var err error
+		switch {
+		case has("State") && has("HostConfig"):
+			var containerJSON container.InspectResponse
+			if err = json.Unmarshal(object, &containerJSON); err == nil {
+				c.containers = append(c.containers, containerJSON)
+			}
+		case has("RepoTags") || has("RootFS"):
+			var imageJSON image.InspectResponse
+			if err = json.Unmarshal(object, &imageJSON); err == nil {
+				c.images = append(c.images, imageJSON)
+			}
+		case has("Mountpoint"):
+			var volumeJSON volume.Volume
+			if err = json.Unmarshal(object, &volumeJSON); err == nil {
+				c.volumes = append(c.volumes, volumeJSON)
+			}
+		case has("IPAM"):
+			var networkJSON network.Inspect
+			if err = json.Unmarshal(object, &networkJSON); err == nil {
+				c.networks = append(c.networks, networkJSON)
+			}
+		case has("Spec") && has("Version"):
+			var serviceJSON swarm.Service
+			if err = json.Unmarshal(object, &serviceJSON); err == nil {
+				c.services = append(c.services, serviceJSON)
+			}
+		default:
+			return fmt.Errorf("unknown inspect object %.40s", object)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesID reports whether ref is id, an abbreviation of at least 12
+// characters of it, or either with the sha256: prefix.
+func matchesID(ref, id string) bool {
+	ref, id = strings.TrimPrefix(ref, "sha256:"), strings.TrimPrefix(id, "sha256:")
+	return id != "" && (ref == id || (len(ref) >= 12 && strings.HasPrefix(id, ref)))
+}
+
+// sameImageRef reports whether a and b name the same image, treating "nginx"
+// and "docker.io/library/nginx:latest" as equal.
+func sameImageRef(a, b string) bool {
+	if a == b {
+		return true
+	}
+	namedA, errA := reference.ParseNormalizedNamed(a)
+	namedB, errB := reference.ParseNormalizedNamed(b)
+	return errA == nil && errB == nil && reference.TagNameOnly(namedA).String() == reference.TagNameOnly(namedB).String()
+}
+
+func notFound(kind, ref string) error {
+	return errdefs.NotFound(fmt.Errorf("No such %s: %s", kind, ref))
+}
+
+func (c *InspectClient) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	for _, containerJSON := range c.containers {
+		if matchesID(containerID, containerJSON.ID) || strings.TrimPrefix(containerJSON.Name, "/") == strings.TrimPrefix(containerID, "/") {
+			return containerJSON, nil
+		}
+	}
+	return container.InspectResponse{}, notFound("container", containerID)
+}
+
+// ContainerList lists every loaded container, the filters are not applied.
+func (c *InspectClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	summaries := make([]container.Summary, 0, len(c.containers))
+	for _, containerJSON := range c.containers {
+		summary := container.Summary{ID: containerJSON.ID, Names: []string{containerJSON.Name}, ImageID: containerJSON.Image}
+		if containerJSON.Config != nil {
+			summary.Image = containerJSON.Config.Image
+			summary.Labels = containerJSON.Config.Labels
+		}
+		if containerJSON.State != nil {
+			summary.State = containerJSON.State.Status
+			summary.Status = containerJSON.State.Status
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+func (c *InspectClient) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	for _, imageJSON := range c.images {
+		if matchesID(imageID, imageJSON.ID) {
+			return imageJSON, nil
+		}
+		for _, ref := range append(append([]string(nil), imageJSON.RepoTags...), imageJSON.RepoDigests...) {
+			if sameImageRef(ref, imageID) {
+				return imageJSON, nil
+			}
+		}
+	}
+	return image.InspectResponse{}, notFound("image", imageID)
+}
+
+func (c *InspectClient) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	for _, volumeJSON := range c.volumes {
+		if volumeJSON.Name == volumeID {
+			return volumeJSON, nil
+		}
+	}
+	return volume.Volume{}, notFound("volume", volumeID)
+}
+
+func (c *InspectClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	for _, networkJSON := range c.networks {
+		if networkJSON.Name == networkID || matchesID(networkID, networkJSON.ID) {
+			return networkJSON, nil
+		}
+	}
+	return network.Inspect{}, notFound("network", networkID)
+}
+
+func (c *InspectClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, opts types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	for _, service := range c.services {
+		if service.Spec.Name == serviceID || matchesID(serviceID, service.ID) {
+			raw, err := json.Marshal(service)
+			return service, raw, err
+		}
+	}
+	return swarm.Service{}, nil, notFound("service", serviceID)
+}
+
+// DistributionInspect reports every image as unknown to the registry.
+func (c *InspectClient) DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	return registry.DistributionInspect{}, notFound("registry image", imageRef)
+}
+
+func (c *InspectClient) Info(ctx context.Context) (system.Info, error) {
+	return system.Info{}, nil
+}
+
+func (c *InspectClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{}, nil
+}
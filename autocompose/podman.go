@@ -0,0 +1,69 @@
+package autocompose
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Engines whose inspect output the generation understands.
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+// DetectEngine asks the daemon for its version components, podman's
+// docker-compatible API reports itself as "Podman Engine".
+func DetectEngine(ctx context.Context, cli Client) string {
+	version, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return EngineDocker
+	}
+	for _, component := range version.Components {
+		if strings.Contains(strings.ToLower(component.Name), EnginePodman) {
+			return EnginePodman
+		}
+	}
+	return EngineDocker
+}
+
+// normalizePodmanContainer rewrites the parts of a podman inspect response that
+// differ from what the docker engine reports.
+func normalizePodmanContainer(containerJSON *container.InspectResponse) {
+	if containerJSON.HostConfig != nil {
+		// Podman reports e.g. "Always" and uses "" for no restart policy
+		policy := strings.ToLower(string(containerJSON.HostConfig.RestartPolicy.Name))
+		if policy == "" {
+			policy = string(container.RestartPolicyDisabled)
+		}
+		containerJSON.HostConfig.RestartPolicy.Name = container.RestartPolicyMode(policy)
+	}
+
+	for i, mountPoint := range containerJSON.Mounts {
+		switch strings.ToLower(string(mountPoint.Type)) {
+		case "volume":
+			mountPoint.Type = mount.TypeVolume
+		case "bind", "":
+			// Podman leaves the type empty on some versions, named volumes still carry a name
+			if mountPoint.Name != "" {
+				mountPoint.Type = mount.TypeVolume
+			} else {
+				mountPoint.Type = mount.TypeBind
+			}
+		case "tmpfs":
+			mountPoint.Type = mount.TypeTmpfs
+		}
+		containerJSON.Mounts[i] = mountPoint
+	}
+}
+
+// isEngineLabel reports labels the engine adds on its own, which would not be
+// reproduced by compose and must not be exported.
+func isEngineLabel(key, engine string) bool {
+	if engine != EnginePodman {
+		return false
+	}
+	return strings.HasPrefix(key, "io.podman.") || strings.HasPrefix(key, "io.containers.") || key == "PODMAN_SYSTEMD_UNIT"
+}
@@ -0,0 +1,334 @@
+package autocompose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// stackNamespaceLabel is set by docker stack deploy on everything it creates.
+const stackNamespaceLabel = "com.docker.stack.namespace"
+
+// GenerateServices inspects the named swarm services and translates their
+// specs into one compose file. Services that fail are left out and their
+// errors returned joined, along with the file of the others.
+func GenerateServices(ctx context.Context, cli Client, names []string, opts Options) (ComposeFile, []Warning, error) {
+	g := &generator{ctx: ctx, cli: cli, opts: opts}
+	compose := newComposeFile()
+
+	var projects []string
+	var errs []error
+	for _, name := range names {
+		svc, _, err := g.cli.ServiceInspectWithRaw(g.ctx, name, types.ServiceInspectOptions{})
+		if err == nil {
+			var serviceCompose ComposeFile
+			serviceCompose, err = g.generateServiceCompose(svc)
+			if err == nil {
+				mergeCompose(&compose, serviceCompose)
+				projects = append(projects, serviceCompose.Name)
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("Error inspecting service %s: %w", name, err))
+	}
+	g.nameProject(&compose, projects)
+	return compose, g.warnings, errors.Join(errs...)
+}
+
+// generateServiceCompose translates a swarm service spec, which unlike its
+// task containers carries the replicas, placement and restart policy, into a
+// compose service with a deploy section.
+func (g *generator) generateServiceCompose(svc swarm.Service) (ComposeFile, error) {
+	compose := newComposeFile()
+
+	spec := svc.Spec
+	containerSpec := spec.TaskTemplate.ContainerSpec
+	if containerSpec == nil {
+		return compose, fmt.Errorf("service %s does not run containers", spec.Name)
+	}
+
+	// Services deployed by docker stack deploy are prefixed with the stack name
+	name := spec.Name
+	stack := spec.Labels[stackNamespaceLabel]
+	if stack != "" {
+		compose.Name = stack
+		name = strings.TrimPrefix(name, stack+"_")
+	}
+
+	// Swarm pins the image digest when the service is created
+	image := containerSpec.Image
+	if i := strings.Index(image, "@"); i >= 0 && !g.opts.PinDigest {
+		image = image[:i]
+	}
+
+	service := ComposeService{
+		Image:       image,
+		Environment: parseEnv(containerSpec.Env),
+		Labels:      stackLabels(containerSpec.Labels),
+		Entrypoint:  containerSpec.Command,
+		Cmd:         containerSpec.Args,
+		Hostname:    containerSpec.Hostname,
+		User:        containerSpec.User,
+		WorkingDir:  containerSpec.Dir,
+		Tty:         containerSpec.TTY,
+		StdinOpen:   containerSpec.OpenStdin,
+		StopSignal:  containerSpec.StopSignal,
+		Init:        containerSpec.Init,
+		CapAdd:      containerSpec.CapabilityAdd,
+		CapDrop:     containerSpec.CapabilityDrop,
+		GroupAdd:    containerSpec.Groups,
+		OomScoreAdj: int(containerSpec.OomScoreAdj),
+		Networks:    make(ComposeServiceNetworks),
+	}
+
+	if period := containerSpec.StopGracePeriod; period != nil {
+		service.StopGracePeriod = formatDuration(*period)
+		if service.StopGracePeriod == "" {
+			service.StopGracePeriod = "0s"
+		}
+	}
+	if containerSpec.Healthcheck != nil {
+		service.Healthcheck = composeHealthcheck(containerSpec.Healthcheck)
+	}
+	if dns := containerSpec.DNSConfig; dns != nil {
+		service.Dns = dns.Nameservers
+		service.DnsSearch = dns.Search
+		service.DnsOptions = dns.Options
+	}
+	// Swarm keeps extra hosts in the /etc/hosts "IP name [aliases...]" format
+	for _, host := range containerSpec.Hosts {
+		fields := strings.Fields(host)
+		for _, hostname := range fields[min(1, len(fields)):] {
+			service.ExtraHosts = append(service.ExtraHosts, hostname+":"+fields[0])
+		}
+	}
+	sort.Strings(service.ExtraHosts)
+	for _, ulimit := range containerSpec.Ulimits {
+		if service.Ulimits == nil {
+			service.Ulimits = make(map[string]ComposeUlimit)
+		}
+		service.Ulimits[ulimit.Name] = ComposeUlimit{Soft: ulimit.Soft, Hard: ulimit.Hard}
+	}
+
+	for _, hostMount := range containerSpec.Mounts {
+		mountPoint := container.MountPoint{
+			Type:        hostMount.Type,
+			Source:      hostMount.Source,
+			Destination: hostMount.Target,
+			RW:          !hostMount.ReadOnly,
+		}
+		if hostMount.BindOptions != nil {
+			mountPoint.Propagation = hostMount.BindOptions.Propagation
+		}
+		switch hostMount.Type {
+		case mount.TypeVolume:
+			if hostMount.Source == "" {
+				// Anonymous volume, compose creates a new one for every task
+				service.Volumes = append(service.Volumes, ComposeServiceVolume{Short: hostMount.Target})
+				continue
+			}
+			volumeKey := hostMount.Source
+			composeVolume := ComposeVolume{Name: hostMount.Source, External: true}
+			if stack != "" && strings.HasPrefix(hostMount.Source, stack+"_") {
+				// Volumes of the stack are declared by their unprefixed name
+				volumeKey = strings.TrimPrefix(hostMount.Source, stack+"_")
+				composeVolume = ComposeVolume{}
+				if options := hostMount.VolumeOptions; options != nil && options.DriverConfig != nil {
+					composeVolume.Driver = options.DriverConfig.Name
+					composeVolume.DriverOpts = options.DriverConfig.Options
+				}
+			}
+			service.Volumes = append(service.Volumes, serviceVolume(volumeKey, mountPoint, &hostMount, g.opts.LongMounts))
+			compose.Volumes[volumeKey] = composeVolume
+		case mount.TypeBind:
+			service.Volumes = append(service.Volumes, serviceVolume(g.bindSource(hostMount.Source), mountPoint, &hostMount, g.opts.LongMounts))
+		case mount.TypeTmpfs:
+			if g.opts.LongMounts {
+				service.Volumes = append(service.Volumes, serviceVolume("", mountPoint, &hostMount, true))
+			} else {
+				service.Tmpfs = append(service.Tmpfs, tmpfsMapping(hostMount.Target, &hostMount))
+			}
+		default:
+			g.warn(name, "%s mount at %s cannot be represented in compose and was skipped", hostMount.Type, hostMount.Target)
+		}
+	}
+
+	// Secrets default to /run/secrets/<name> and configs to /<name>, both
+	// readable by everyone
+	for _, ref := range containerSpec.Secrets {
+		secret := ComposeFileReference{Source: ref.SecretName}
+		if ref.File != nil {
+			if ref.File.Name != ref.SecretName && ref.File.Name != "/run/secrets/"+ref.SecretName {
+				secret.Target = ref.File.Name
+			}
+			if ref.File.Mode != 0444 {
+				secret.Mode = fmt.Sprintf("%#o", uint32(ref.File.Mode))
+			}
+		}
+		service.Secrets = append(service.Secrets, secret)
+		compose.Secrets[ref.SecretName] = ComposeSecret{External: true, Name: ref.SecretName}
+	}
+	for _, ref := range containerSpec.Configs {
+		if ref.File == nil {
+			// Runtime configs such as credential specs have no file to mount
+			continue
+		}
+		config := ComposeFileReference{Source: ref.ConfigName}
+		if ref.File.Name != "/"+ref.ConfigName {
+			config.Target = ref.File.Name
+		}
+		if ref.File.Mode != 0444 {
+			config.Mode = fmt.Sprintf("%#o", uint32(ref.File.Mode))
+		}
+		service.Configs = append(service.Configs, config)
+		compose.Configs[ref.ConfigName] = ComposeConfig{External: true, Name: ref.ConfigName}
+	}
+
+	attachments := spec.TaskTemplate.Networks
+	if len(attachments) == 0 {
+		// Services created before API 1.44 keep their networks in the spec
+		attachments = spec.Networks
+	}
+	for _, attachment := range attachments {
+		networkName := attachment.Target
+		networkInspect, err := g.cli.NetworkInspect(g.ctx, attachment.Target, network.InspectOptions{})
+		if g.lookupFailed(err) {
+			return compose, g.err
+		} else if err == nil {
+			networkName = networkInspect.Name
+		}
+		var settings *ComposeServiceNetwork
+		for _, alias := range attachment.Aliases {
+			// docker stack deploy adds the service name as an alias
+			if alias != name {
+				if settings == nil {
+					settings = &ComposeServiceNetwork{}
+				}
+				settings.Aliases = append(settings.Aliases, alias)
+			}
+		}
+		networkKey := networkName
+		composeNetwork := ComposeNetwork{External: true, Name: networkName}
+		if stack != "" && strings.HasPrefix(networkName, stack+"_") {
+			// Networks of the stack are declared by their unprefixed name, like volumes
+			networkKey = strings.TrimPrefix(networkName, stack+"_")
+			composeNetwork = ComposeNetwork{}
+		}
+		service.Networks[networkKey] = settings
+		compose.Networks[networkKey] = composeNetwork
+	}
+
+	deploy := &ComposeDeploy{Labels: stackLabels(spec.Labels)}
+	if endpoint := spec.EndpointSpec; endpoint != nil {
+		for _, port := range endpoint.Ports {
+			if port.PublishMode == swarm.PortConfigPublishModeHost {
+				// The publish mode is only available in the long syntax
+				config := &ComposePortConfig{Target: port.TargetPort, Mode: string(port.PublishMode)}
+				if port.PublishedPort != 0 {
+					config.Published = strconv.FormatUint(uint64(port.PublishedPort), 10)
+				}
+				if port.Protocol != swarm.PortConfigProtocolTCP {
+					config.Protocol = string(port.Protocol)
+				}
+				service.Ports = append(service.Ports, ComposeServicePort{Long: config})
+				continue
+			}
+			mapping := portMapping{containerPort: int(port.TargetPort), proto: string(port.Protocol), count: 1}
+			if port.PublishedPort != 0 {
+				mapping.hostPort = strconv.FormatUint(uint64(port.PublishedPort), 10)
+			}
+			service.Ports = append(service.Ports, ComposeServicePort{Short: mapping.String()})
+		}
+		if endpoint.Mode == swarm.ResolutionModeDNSRR {
+			deploy.EndpointMode = string(endpoint.Mode)
+		}
+	}
+
+	switch {
+	case spec.Mode.Global != nil:
+		deploy.Mode = "global"
+	case spec.Mode.ReplicatedJob != nil:
+		deploy.Mode = "replicated-job"
+	case spec.Mode.GlobalJob != nil:
+		deploy.Mode = "global-job"
+	case spec.Mode.Replicated != nil:
+		deploy.Replicas = spec.Mode.Replicated.Replicas
+	}
+
+	if resources := spec.TaskTemplate.Resources; resources != nil {
+		if limits := resources.Limits; limits != nil && (limits.NanoCPUs > 0 || limits.MemoryBytes > 0 || limits.Pids > 0) {
+			deploy.Resources.Limits = &ComposeLimits{Pids: limits.Pids}
+			if limits.NanoCPUs > 0 {
+				deploy.Resources.Limits.Cpus = fmt.Sprintf("%.2f", float64(limits.NanoCPUs)/1e9)
+			}
+			if limits.MemoryBytes > 0 {
+				deploy.Resources.Limits.Memory = formatBytes(limits.MemoryBytes)
+			}
+		}
+		if reservations := resources.Reservations; reservations != nil && (reservations.NanoCPUs > 0 || reservations.MemoryBytes > 0) {
+			deploy.Resources.Reservations = &ComposeReservations{}
+			if reservations.NanoCPUs > 0 {
+				deploy.Resources.Reservations.Cpus = fmt.Sprintf("%.2f", float64(reservations.NanoCPUs)/1e9)
+			}
+			if reservations.MemoryBytes > 0 {
+				deploy.Resources.Reservations.Memory = formatBytes(reservations.MemoryBytes)
+			}
+		}
+	}
+
+	if policy := spec.TaskTemplate.RestartPolicy; policy != nil {
+		deploy.RestartPolicy = &ComposeRestartPolicy{
+			Condition:   string(policy.Condition),
+			MaxAttempts: policy.MaxAttempts,
+		}
+		if policy.Delay != nil {
+			deploy.RestartPolicy.Delay = formatDuration(*policy.Delay)
+		}
+		if policy.Window != nil {
+			deploy.RestartPolicy.Window = formatDuration(*policy.Window)
+		}
+	}
+
+	if placement := spec.TaskTemplate.Placement; placement != nil &&
+		(len(placement.Constraints) > 0 || len(placement.Preferences) > 0 || placement.MaxReplicas > 0) {
+		deploy.Placement = &ComposePlacement{
+			Constraints:        placement.Constraints,
+			MaxReplicasPerNode: placement.MaxReplicas,
+		}
+		for _, preference := range placement.Preferences {
+			if preference.Spread != nil {
+				deploy.Placement.Preferences = append(deploy.Placement.Preferences, ComposePlacementPreference{Spread: preference.Spread.SpreadDescriptor})
+			}
+		}
+	}
+	service.Deploy = deploy
+
+	service.escapeDollars()
+	compose.Services[name] = service
+	return compose, nil
+}
+
+// stackLabels returns labels without the ones docker stack deploy adds, or nil
+// if none are left.
+func stackLabels(labels map[string]string) StringMap {
+	var result StringMap
+	for key, value := range labels {
+		if key == stackNamespaceLabel {
+			continue
+		}
+		if result == nil {
+			result = make(StringMap)
+		}
+		result[key] = value
+	}
+	return result
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"docker-autocompose/autocompose"
+	"gopkg.in/yaml.v3"
+)
+
+// diffCompose compares the generated services against the matching services
+// of an existing compose file and prints the drift to w, "+" for what only
+// the container has and "-" for what only the file has. It reports whether
+// any service drifted.
+func diffCompose(compose autocompose.ComposeFile, existingFile string, w io.Writer) (bool, error) {
+	data, err := os.ReadFile(existingFile)
+	if err != nil {
+		return false, withKind(errUsage, fmt.Errorf("Error reading %s: %v", existingFile, err))
+	}
+	var existing map[string]interface{}
+	if err := yaml.Unmarshal(data, &existing); err != nil {
+		return false, withKind(errUsage, fmt.Errorf("Error parsing %s: %v", existingFile, err))
+	}
+	existingServices, _ := existing["services"].(map[string]interface{})
+
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "--- %s\n+++ containers\n", existingFile)
+	drifted := false
+	for _, name := range names {
+		service := compose.Services[name]
+		generated, err := genericValue(service)
+		if err != nil {
+			return false, err
+		}
+
+		existingName, found := matchService(existingServices, name, service.ContainerName)
+		if !found {
+			fmt.Fprintf(w, "%s: not in %s\n", name, existingFile)
+			drifted = true
+			continue
+		}
+
+		var lines []string
+		diffValues("", existingServices[existingName], generated, &lines)
+		if len(lines) == 0 {
+			continue
+		}
+		drifted = true
+		fmt.Fprintf(w, "%s:\n", existingName)
+		for _, line := range lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	return drifted, nil
+}
+
+// matchService finds the existing service for a generated one, by service
+// name first and by container name otherwise.
+func matchService(services map[string]interface{}, name, containerName string) (string, bool) {
+	if _, ok := services[name]; ok {
+		return name, true
+	}
+	if containerName == "" {
+		return "", false
+	}
+	for existingName, service := range services {
+		if fields, ok := service.(map[string]interface{}); ok && fields["container_name"] == containerName {
+			return existingName, true
+		}
+	}
+	return "", false
+}
+
+// genericValue converts v to the maps, lists and scalars it reads back as
+// from YAML, so it can be compared with a parsed file.
+func genericValue(v interface{}) (interface{}, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling YAML: %v", err)
+	}
+	var generic interface{}
+	err = yaml.Unmarshal(data, &generic)
+	return generic, err
+}
+
+// diffValues appends the differences between the existing value have and the
+// generated value want at path to lines.
+func diffValues(path string, have, want interface{}, lines *[]string) {
+	// The environment and labels may be written as a list or a map
+	if key := path[strings.LastIndex(path, ".")+1:]; key == "environment" || key == "labels" {
+		have, want = listToMap(have), listToMap(want)
+	}
+
+	switch wantValue := want.(type) {
+	case map[string]interface{}:
+		haveValue, ok := have.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for key := range wantValue {
+			keys[key] = true
+		}
+		for key := range haveValue {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			keyPath := strings.TrimPrefix(path+"."+key, ".")
+			haveChild, inHave := haveValue[key]
+			wantChild, inWant := wantValue[key]
+			switch {
+			case !inHave && !isDefaultValue(key, wantChild):
+				*lines = append(*lines, fmt.Sprintf("+ %s: %s", keyPath, formatValue(wantChild)))
+			case !inWant && !isDefaultValue(key, haveChild):
+				*lines = append(*lines, fmt.Sprintf("- %s: %s", keyPath, formatValue(haveChild)))
+			case inHave && inWant:
+				diffValues(keyPath, haveChild, wantChild, lines)
+			}
+		}
+		return
+	case []interface{}:
+		haveValue, ok := have.([]interface{})
+		if !ok {
+			break
+		}
+		// Lists are compared as sets, their order rarely matters
+		counts := make(map[string]int)
+		for _, item := range haveValue {
+			counts[formatValue(item)]++
+		}
+		for _, item := range wantValue {
+			if counts[formatValue(item)] > 0 {
+				counts[formatValue(item)]--
+			} else {
+				*lines = append(*lines, fmt.Sprintf("+ %s: %s", path, formatValue(item)))
+			}
+		}
+		for _, item := range haveValue {
+			if counts[formatValue(item)] > 0 {
+				counts[formatValue(item)]--
+				*lines = append(*lines, fmt.Sprintf("- %s: %s", path, formatValue(item)))
+			}
+		}
+		return
+	}
+
+	if formatValue(have) != formatValue(want) {
+		*lines = append(*lines, fmt.Sprintf("~ %s: %s -> %s", path, formatValue(have), formatValue(want)))
+	}
+}
+
+// listToMap converts a list of KEY=VALUE strings to a map.
+func listToMap(v interface{}) interface{} {
+	list, ok := v.([]interface{})
+	if !ok {
+		return v
+	}
+	m := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		key, value, _ := strings.Cut(fmt.Sprint(item), "=")
+		m[key] = value
+	}
+	return m
+}
+
+// isDefaultValue reports whether leaving out key is the same as setting it to
+// v, so a file that omits it has not drifted.
+func isDefaultValue(key string, v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !value
+	case int:
+		return value == 0
+	case string:
+		return value == "" || (key == "restart" && value == "no")
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+// formatValue renders v on a single line, scalars as they read back from YAML
+// so that 8080 and "8080" compare equal.
+func formatValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		var node yaml.Node
+		if yaml.Unmarshal(data, &node) == nil && len(node.Content) > 0 {
+			node.Content[0].Style = yaml.FlowStyle
+			if flow, err := yaml.Marshal(node.Content[0]); err == nil {
+				return strings.TrimSpace(string(flow))
+			}
+		}
+		return strings.TrimSpace(string(data))
+	}
+	return fmt.Sprint(v)
+}
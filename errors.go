@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/client"
+)
+
+// Kinds of failure with their own exit status. Errors are tagged with one of
+// them through withKind and exitCode maps them to the status.
+var (
+	errUsage       = errors.New("invalid command line")
+	errDaemon      = errors.New("cannot connect to the Docker daemon, or it did not answer in time")
+	errNotFound    = errors.New("container, service or image not found")
+	errWrite       = errors.New("cannot write the output")
+	errInterrupted = errors.New("interrupted by a signal")
+)
+
+// exitCodes maps the kinds of failure to exit statuses. Any other failure,
+// including drift found by --diff and an invalid compose file, exits with 1.
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{errUsage, 2},
+	{errDaemon, 3},
+	{errNotFound, 4},
+	{errWrite, 5},
+	{errInterrupted, 130},
+}
+
+// kindError tags an error with its kind without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// withKind tags err with kind so exitCode picks its exit status.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// inspectError tags an inspect error as errNotFound when the daemon reports
+// the object does not exist.
+func inspectError(err error) error {
+	if isNotFound(err) {
+		return withKind(errNotFound, err)
+	}
+	return err
+}
+
+// isNotFound reports whether err, or any of the errors it joins such as the
+// per-container errors of Generate, says an object does not exist.
+func isNotFound(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if isNotFound(err) {
+				return true
+			}
+		}
+		return false
+	}
+	return client.IsErrNotFound(err)
+}
+
+// exitCode returns the exit status for err, 0 if it is nil and 1 if it has no
+// kind.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, entry := range exitCodes {
+		if errors.Is(err, entry.kind) {
+			return entry.code
+		}
+	}
+	return 1
+}
+
+// printExitCodes documents the exit statuses for --help.
+func printExitCodes(w io.Writer) {
+	fmt.Fprint(w, "\nExit status:\n  0    success\n  1    other failure, or drift found with --diff\n")
+	for _, entry := range exitCodes {
+		fmt.Fprintf(w, "  %-3d  %s\n", entry.code, entry.kind)
+	}
+}
+
+// fail reports err and exits with its status.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(exitCode(err))
+}
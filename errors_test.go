@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"docker-autocompose/autocompose"
+)
+
+func TestExitCode(t *testing.T) {
+	_, _, missing := autocompose.Generate(context.Background(), &autocompose.InspectClient{}, []string{"missing"}, autocompose.Options{})
+	_, _, missingService := autocompose.GenerateServices(context.Background(), &autocompose.InspectClient{}, []string{"missing"}, autocompose.Options{})
+	_, unreadableDiff := diffCompose(autocompose.ComposeFile{}, filepath.Join(t.TempDir(), "missing.yml"), io.Discard)
+	notCompose := filepath.Join(t.TempDir(), "compose.yml")
+	if err := os.WriteFile(notCompose, []byte("- a list\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := autocompose.ComposeFile{}.Node(autocompose.MarshalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, mergeIntoList := mergeComposeFile(doc, notCompose, false)
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"untagged", errors.New("drift found"), 1},
+		{"usage", withKind(errUsage, errors.New("bad flag")), 2},
+		{"unreadable --diff file", unreadableDiff, 2},
+		{"--merge into a list", mergeIntoList, 2},
+		{"daemon", fmt.Errorf("Error listing containers: %w", withKind(errDaemon, errors.New("timeout"))), 3},
+		{"missing container", inspectError(missing), 4},
+		{"missing service", inspectError(missingService), 4},
+		{"missing among others", inspectError(errors.Join(errors.New("permission denied"), missing)), 4},
+		{"write", withKind(errWrite, errors.New("read-only file system")), 5},
+		{"interrupted", interrupted(cancelledContext(), errors.New("context canceled")), 130},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"docker-autocompose/autocompose"
+)
+
+// Options controls how containers are selected and how the generated compose
+// file is written. The embedded options control how they are translated.
+type Options struct {
+	autocompose.Options
+
+	Host            string        // daemon to connect to, e.g. unix://, tcp:// or ssh://user@host
+	Output          string        // compose file to write, stdout when empty
+	Diff            string        // compare against this compose file instead of writing one
+	Merge           bool          // add the services to the existing output file instead of overwriting it
+	ReplaceServices bool          // with Merge, replace services that already exist in the file
+	ExportAll       bool          // export every container matching the filters
+	Statuses        []string      // container states to list or export, see statusFilters
+	Regex           bool          // treat container arguments as regular expressions
+	Interactive     bool          // pick the containers to export from a terminal list
+	Services        []string      // swarm services to export from their service spec
+	Format          string        // listing format: a Go template, "json" or "table" by default
+	Quiet           bool          // listing only prints container IDs, exporting prints no warnings or status
+	Verbose         bool          // log the API calls and the values the image comparison left out
+	RelativeBinds   bool          // write bind sources under RelativeTo, or the output directory, as ./ paths
+	RelativeTo      string        // base directory for RelativeBinds
+	Timeout         time.Duration // give up on API calls the daemon does not answer within it, 0 waits forever
+	PullPolicy      string        // pull_policy set on every service
+	EnvFileOut      string        // write environment variables to dotenv files instead of inline
+	EnvList         bool          // emit the environment as a list of KEY=VALUE strings
+	MaskSecrets     bool          // replace secret-looking environment values with ${VARIABLES}
+	Redact          bool          // with MaskSecrets, drop the real values instead of writing .env
+	SecretKeys      []string      // substrings that mark an environment key as secret
+	Anonymize       bool          // strip values identifying the host so the file can be shared
+	Parameterize    []string      // host-specific values ("ports", "binds") replaced with ${VARIABLES}
+	Version         string        // legacy top-level version for docker-compose 1.x
+	Filters         []string      // docker ps style key=value filters for bulk export
+	NoValidate      bool          // skip validating the output against the compose specification
+	NoHeader        bool          // leave out the comment block naming the source of the file
+	ExcludeFields   []string      // service keys left out of the output
+	Annotate        bool          // comment on values that were derived or left out
+	Dedupe          bool          // factor the values all services share into an x-common block
+	Metadata        bool          // record the source container of each service in x-autocompose
+}
+
+// presets return the service keys each --preset leaves out.
+var presets = map[string]func() []string{
+	"minimal": func() []string {
+		keep := map[string]bool{"image": true, "build": true, "ports": true, "volumes": true, "environment": true, "env_file": true}
+		var excluded []string
+		for _, key := range autocompose.ServiceKeys() {
+			if !keep[key] {
+				excluded = append(excluded, key)
+			}
+		}
+		return excluded
+	},
+	"standard": func() []string {
+		return []string{
+			"cpuset", "memswap_limit", "oom_kill_disable", "oom_score_adj", "blkio_config", "pids_limit",
+			"stop_signal", "shell", "dns_opt", "ulimits", "group_add", "runtime", "cgroup", "domainname",
+			"stdin_open", "stdin_once",
+		}
+	},
+	"full": func() []string {
+		return nil
+	},
+}
+
+const usageHeader = `Usage:
+  docker-autocompose [options] <container>... [-o compose.yml]
+  docker-autocompose [options] --all [-o compose.yml]
+  docker-autocompose [options] --project <name> [-o compose.yml]
+  docker-autocompose [options] --interactive [-o compose.yml]
+  docker-autocompose [options] --service <name>... [-o compose.yml]
+  docker-autocompose
+
+Without a container, lists the containers on the host.
+
+Options:
+`
+
+// parseFlags parses the command line into Options and the positional container
+// arguments. Flags may appear before or after the positional arguments.
+func parseFlags(argv []string) (Options, []string, error) {
+	opts := Options{Options: autocompose.Options{ProfileLabel: "autocompose.profile"}, SecretKeys: defaultSecretKeys}
+
+	fs := flag.NewFlagSet("docker-autocompose", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usageHeader)
+		fs.PrintDefaults()
+		printExitCodes(fs.Output())
+	}
+	fs.StringVar(&opts.Host, "H", "", "Docker daemon `host` to connect to (tcp://, unix://, npipe:// or ssh://user@host)")
+	fs.DurationVar(&opts.Timeout, "timeout", 30*time.Second, "give up on API calls the daemon does not answer within `duration`, 0 to wait forever")
+	fs.StringVar(&opts.Host, "host", "", "Docker daemon `host` to connect to (tcp://, unix://, npipe:// or ssh://user@host)")
+	fs.Func("engine", "force the container `engine` (docker or podman) instead of detecting it", func(value string) error {
+		if value != autocompose.EngineDocker && value != autocompose.EnginePodman {
+			return fmt.Errorf("must be %s or %s", autocompose.EngineDocker, autocompose.EnginePodman)
+		}
+		opts.Engine = value
+		return nil
+	})
+	fs.StringVar(&opts.Output, "o", "", "write the compose file to `file` instead of stdout")
+	fs.StringVar(&opts.Output, "output", "", "write the compose file to `file` instead of stdout")
+	fs.StringVar(&opts.Diff, "diff", "", "print how the containers drifted from the services in an existing compose `file` and exit 1 if they did")
+	fs.BoolVar(&opts.Merge, "merge", false, "add the services to the existing -o file, keeping everything else in it")
+	fs.BoolFunc("replace-service", "with --merge, replace services that already exist in the file", func(string) error {
+		opts.ReplaceServices = true
+		return nil
+	})
+	fs.BoolFunc("fail-if-exists", "with --merge, fail if a service already exists in the file (default)", func(string) error {
+		opts.ReplaceServices = false
+		return nil
+	})
+	fs.BoolVar(&opts.ExportAll, "all", false, "export every container on the host")
+	fs.BoolVar(&opts.Interactive, "interactive", false, "pick the containers to export from an interactive list")
+	fs.BoolVar(&opts.Interactive, "i", false, "shorthand for --interactive")
+	fs.StringVar(&opts.Format, "format", "", "format the container listing with a Go `template`, or \"json\" for one object per line")
+	fs.BoolVar(&opts.Quiet, "q", false, "only print container IDs when listing, and no warnings or status messages when exporting")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "only print container IDs when listing, and no warnings or status messages when exporting")
+	fs.BoolVar(&opts.Verbose, "v", false, "shorthand for --verbose")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "log the API calls made and the values left out because they match the image")
+	fs.BoolVar(&opts.Regex, "regex", false, "match container arguments as regular expressions against container names")
+	fs.Func("status", "only list or export containers in `state` running, exited, paused, created or all (repeatable, export defaults to running)", func(value string) error {
+		switch value {
+		case "running", "exited", "paused", "created", "restarting", "dead", "all":
+			opts.Statuses = append(opts.Statuses, value)
+			return nil
+		}
+		return fmt.Errorf("unknown container state %q", value)
+	})
+	fs.BoolFunc("running-only", "same as --status running", func(string) error {
+		opts.Statuses = append(opts.Statuses, "running")
+		return nil
+	})
+	fs.Func("filter", "only export containers matching a docker ps style `key=value` filter (repeatable, implies --all)", func(value string) error {
+		opts.Filters = append(opts.Filters, value)
+		return nil
+	})
+	fs.Func("service", "export the swarm service `name` from its service spec (repeatable)", func(value string) error {
+		opts.Services = append(opts.Services, value)
+		return nil
+	})
+	fs.StringVar(&opts.Project, "project", "", "export every container of the compose project `name`")
+	fs.IntVar(&opts.Jobs, "jobs", 0, "inspect up to `n` containers concurrently (default 4 per CPU)")
+	fs.BoolVar(&opts.PinAssignedPorts, "pin-assigned-ports", false, "keep the host ports the engine picked for ports published without one (-p 8080, -P)")
+	fs.BoolVar(&opts.NoPortRanges, "no-port-ranges", false, "emit one ports entry per port instead of collapsing ranges")
+	fs.BoolVar(&opts.PinDigest, "pin-digest", false, "reference images by repo digest instead of tag")
+	fs.BoolVar(&opts.BuildHint, "build-hint", false, "add a build section for locally built images")
+	fs.Func("pull-policy", "set the pull_policy of every service to `policy` always, never, missing or build", func(value string) error {
+		switch value {
+		case "always", "never", "missing", "build":
+			opts.PullPolicy = value
+			return nil
+		}
+		return fmt.Errorf("unknown pull policy %q", value)
+	})
+	fs.Func("ignore-label-prefix", "leave out container labels starting with `prefix`, on top of the defaults (repeatable, empty to keep every label; default "+strings.Join(autocompose.DefaultIgnoreLabelPrefixes, ",")+")", func(value string) error {
+		switch {
+		case value == "":
+			opts.IgnoreLabelPrefixes = []string{}
+		case opts.IgnoreLabelPrefixes == nil:
+			opts.IgnoreLabelPrefixes = append(slices.Clone(autocompose.DefaultIgnoreLabelPrefixes), value)
+		default:
+			opts.IgnoreLabelPrefixes = append(opts.IgnoreLabelPrefixes, value)
+		}
+		return nil
+	})
+	fs.StringVar(&opts.ProfileLabel, "profile-label", opts.ProfileLabel, "container label `key` holding the comma separated profiles of a service")
+	fs.StringVar(&opts.EnvFileOut, "env-file-out", "", "write the environment to dotenv file(s) at `path` and reference them via env_file")
+	fs.Func("env-style", "emit the environment as a `map` (default) or a list of KEY=VALUE strings", func(value string) error {
+		if value != "map" && value != "list" {
+			return fmt.Errorf("must be map or list")
+		}
+		opts.EnvList = value == "list"
+		return nil
+	})
+	fs.BoolVar(&opts.FullEnv, "full-env", false, "include environment variables inherited from the image")
+	fs.BoolVar(&opts.MaskSecrets, "mask-secrets", false, "replace secret-looking environment values with ${VARIABLES} stored in .env")
+	fs.BoolVar(&opts.Redact, "redact", false, "like --mask-secrets, but drop the real values")
+	fs.BoolVar(&opts.Anonymize, "anonymize", false, "redact environment values, replace bind paths with placeholders and drop labels, hostnames and static IPs for sharing the file")
+	fs.Func("secret-patterns", "comma separated `list` of key substrings treated as secret (default "+strings.Join(defaultSecretKeys, ",")+")", func(value string) error {
+		opts.SecretKeys = strings.Split(value, ",")
+		return nil
+	})
+	fs.Func("parameterize", "comma separated `kinds` of host-specific values (ports, binds) to replace with ${VARIABLES} stored in .env", func(value string) error {
+		for _, kind := range strings.Split(value, ",") {
+			kind = strings.TrimSpace(kind)
+			if kind != "ports" && kind != "binds" {
+				return fmt.Errorf("unknown kind %q, must be ports or binds", kind)
+			}
+			if !slices.Contains(opts.Parameterize, kind) {
+				opts.Parameterize = append(opts.Parameterize, kind)
+			}
+		}
+		return nil
+	})
+	fs.BoolVar(&opts.KeepHostname, "keep-hostname", false, "always emit the container hostname, even when it looks generated")
+	fs.BoolVar(&opts.RelativeBinds, "relative-binds", false, "write bind mount sources under the output file's directory as ./ relative paths")
+	fs.Func("relative-to", "base `directory` for --relative-binds instead of the output file's (implies --relative-binds)", func(value string) error {
+		opts.RelativeBinds, opts.RelativeTo = true, value
+		return nil
+	})
+	fs.BoolVar(&opts.LongMounts, "long-mounts", false, "emit every mount in the long volume syntax")
+	fs.BoolVar(&opts.KeepAnonymousVolumes, "keep-anonymous-volumes", false, "reference anonymous volumes by their generated name to reuse their data")
+	fs.BoolVar(&opts.Metadata, "metadata", false, "record the source container, its creation time, the image and the tool version of each service in an x-autocompose mapping")
+	fs.BoolVar(&opts.Dedupe, "dedupe", false, "move the values all services share into an x-common block merged into each service")
+	fs.BoolVar(&opts.Annotate, "annotate", false, "explain derived and omitted values in YAML comments")
+	fs.Func("exclude-fields", "comma separated `keys` to leave out of every service, e.g. container_name,labels", func(value string) error {
+		keys := autocompose.ServiceKeys()
+		for _, key := range strings.Split(value, ",") {
+			key = strings.TrimSpace(key)
+			if !slices.Contains(keys, key) {
+				return fmt.Errorf("unknown service key %q", key)
+			}
+			opts.ExcludeFields = append(opts.ExcludeFields, key)
+		}
+		return nil
+	})
+	fs.Func("preset", "`preset` of keys to emit: minimal (image, ports, volumes and environment), standard (no low-level tuning) or full", func(value string) error {
+		preset, ok := presets[value]
+		if !ok {
+			return fmt.Errorf("must be minimal, standard or full")
+		}
+		opts.ExcludeFields = append(opts.ExcludeFields, preset()...)
+		return nil
+	})
+	fs.BoolVar(&opts.NoHeader, "no-header", false, "leave out the comment block saying where the file was generated from")
+	fs.BoolVar(&opts.NoValidate, "no-validate", false, "write the output even if it does not validate against the compose specification")
+	fs.BoolVar(&opts.LiteralNames, "literal-names", false, "name services after their container and keep container_name, even for containers created by compose")
+	fs.StringVar(&opts.ProjectName, "project-name", "", "top-level compose project `name`")
+	fs.StringVar(&opts.Version, "compose-version", "", "emit a legacy top-level `version` for docker-compose 1.x")
+
+	var args []string
+	for {
+		if err := fs.Parse(argv); err != nil {
+			return opts, nil, err
+		}
+		argv = fs.Args()
+		if len(argv) == 0 {
+			break
+		}
+		args = append(args, argv[0])
+		argv = argv[1:]
+	}
+
+	if opts.Redact {
+		opts.MaskSecrets = true
+	}
+	if opts.Anonymize {
+		// The header names the host and the containers, the metadata holds
+		// container and image IDs and the notes quote hostnames and paths
+		opts.NoHeader = true
+		opts.Metadata = false
+		opts.Annotate = false
+	}
+	if opts.Project != "" {
+		opts.Filters = append(opts.Filters, "label=com.docker.compose.project="+opts.Project, "label=com.docker.compose.oneoff=False")
+	}
+	if len(opts.Filters) > 0 && !opts.Interactive {
+		opts.ExportAll = true
+	}
+
+	// Backward compatibility with the positional output file, which is
+	// recognised by its extension now that several containers can be given
+	if opts.Output == "" && len(args) > 0 {
+		last := args[len(args)-1]
+		isYAML := strings.HasSuffix(last, ".yml") || strings.HasSuffix(last, ".yaml")
+		if (opts.ExportAll && len(args) == 1) || (!opts.ExportAll && len(args) == 2 && isYAML) {
+			opts.Output = last
+			args = args[:len(args)-1]
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Warning: passing the output file as an argument is deprecated, use -o %s\n", opts.Output)
+			}
+		}
+	}
+	if opts.Quiet && opts.Verbose {
+		fmt.Fprintln(fs.Output(), "--quiet and --verbose cannot be combined")
+		fs.Usage()
+		return opts, nil, fmt.Errorf("--quiet with --verbose")
+	}
+	if opts.Merge && opts.Output == "" {
+		fmt.Fprintln(fs.Output(), "--merge needs an output file given with -o")
+		fs.Usage()
+		return opts, nil, fmt.Errorf("--merge without -o")
+	}
+	if opts.Merge && opts.Dedupe {
+		// The services added to the file would alias a block it does not have
+		fmt.Fprintln(fs.Output(), "--merge and --dedupe cannot be combined")
+		fs.Usage()
+		return opts, nil, fmt.Errorf("--merge with --dedupe")
+	}
+	if opts.RelativeBinds {
+		// Without an output file the project directory is the current one
+		base := opts.RelativeTo
+		if base == "" {
+			base = filepath.Dir(opts.Output)
+		}
+		var err error
+		if opts.BindBase, err = filepath.Abs(base); err != nil {
+			return opts, nil, fmt.Errorf("Invalid directory %s: %v", base, err)
+		}
+	}
+	if opts.ExportAll && len(args) > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(args, " "))
+		fs.Usage()
+		return opts, nil, fmt.Errorf("unexpected arguments")
+	}
+	return opts, args, nil
+}
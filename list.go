@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"docker-autocompose/autocompose"
+	"github.com/docker/docker/api/types/container"
+)
+
+// listRow is the data available to --format templates, named like the
+// placeholders of docker ps --format.
+type listRow struct {
+	ID      string
+	Names   string
+	Image   string
+	Status  string
+	State   string
+	Project string
+	Labels  map[string]string `json:"-"`
+}
+
+// Label returns the value of a single label, for {{.Label "key"}} templates.
+func (r listRow) Label(key string) string {
+	return r.Labels[key]
+}
+
+// printContainerList prints the containers on the host as a table, one JSON
+// object per line, or through a user supplied template.
+func printContainerList(ctx context.Context, cli autocompose.Client, opts Options) error {
+	containers, err := listContainers(ctx, cli, opts, "all")
+	if err != nil {
+		return err
+	}
+
+	rows := make([]listRow, len(containers))
+	for i, c := range containers {
+		rows[i] = newListRow(c)
+	}
+
+	switch {
+	case opts.Quiet:
+		for _, row := range rows {
+			fmt.Println(row.ID)
+		}
+	case opts.Format == "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+	case opts.Format == "" || opts.Format == "table":
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(writer, "CONTAINER ID\tNAMES\tIMAGE\tSTATUS\tPROJECT")
+		for _, row := range rows {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", row.ID, row.Names, row.Image, row.Status, row.Project)
+		}
+		return writer.Flush()
+	default:
+		// Tabs in the template are aligned into columns like with docker ps,
+		// which also accepts a "table " prefix
+		format := strings.TrimPrefix(opts.Format, "table ")
+		tmpl, err := template.New("format").Parse(format + "\n")
+		if err != nil {
+			return withKind(errUsage, fmt.Errorf("Invalid format template: %v", err))
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		for _, row := range rows {
+			if err := tmpl.Execute(writer, row); err != nil {
+				return fmt.Errorf("Error formatting container %s: %v", row.ID, err)
+			}
+		}
+		return writer.Flush()
+	}
+	return nil
+}
+
+func newListRow(c container.Summary) listRow {
+	return listRow{
+		ID:      c.ID[:12],
+		Names:   containerName(c.Names),
+		Image:   c.Image,
+		Status:  c.Status,
+		State:   string(c.State),
+		Project: c.Labels["com.docker.compose.project"],
+		Labels:  c.Labels,
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"docker-autocompose/autocompose"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// Status messages and warnings go to stderr so that stdout only ever carries
+// the generated document or the listing. quiet drops them, verbose adds the
+// API calls made and the values the image comparison left out.
+var (
+	quiet   bool
+	verbose bool
+)
+
+// warnf prints a warning unless quiet.
+func warnf(format string, args ...interface{}) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+	}
+}
+
+// infof prints a status message unless quiet.
+func infof(format string, args ...interface{}) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// debugf prints a message only when verbose.
+func debugf(format string, args ...interface{}) {
+	if verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// loggingClient logs every API call before passing it on.
+type loggingClient struct {
+	autocompose.Client
+}
+
+func (c loggingClient) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	debugf("API: inspect container %s", containerID)
+	return c.Client.ContainerInspect(ctx, containerID)
+}
+
+func (c loggingClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	debugf("API: list containers")
+	return c.Client.ContainerList(ctx, options)
+}
+
+func (c loggingClient) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	debugf("API: inspect image %s", imageID)
+	return c.Client.ImageInspect(ctx, imageID, inspectOpts...)
+}
+
+func (c loggingClient) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	debugf("API: inspect volume %s", volumeID)
+	return c.Client.VolumeInspect(ctx, volumeID)
+}
+
+func (c loggingClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	debugf("API: inspect network %s", networkID)
+	return c.Client.NetworkInspect(ctx, networkID, options)
+}
+
+func (c loggingClient) DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	debugf("API: inspect registry image %s", imageRef)
+	return c.Client.DistributionInspect(ctx, imageRef, encodedRegistryAuth)
+}
+
+func (c loggingClient) Info(ctx context.Context) (system.Info, error) {
+	debugf("API: daemon info")
+	return c.Client.Info(ctx)
+}
+
+func (c loggingClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	debugf("API: daemon version")
+	return c.Client.ServerVersion(ctx)
+}
+
+func (c loggingClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, opts types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	debugf("API: inspect service %s", serviceID)
+	return c.Client.ServiceInspectWithRaw(ctx, serviceID, opts)
+}
@@ -2,338 +2,693 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/connhelper"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/api/types/filters"
+	"maps"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
+	"docker-autocompose/autocompose"
 	"github.com/docker/docker/client"
 	"gopkg.in/yaml.v3"
 )
 
-type ComposeService struct {
-	Image           string              `yaml:"image,omitempty"`
-	ContainerName   string              `yaml:"container_name,omitempty"`
-	Ports           []string            `yaml:"ports,omitempty"`
-	Volumes         []string            `yaml:"volumes,omitempty"`
-	Environment     map[string]string   `yaml:"environment,omitempty"`
-	Restart         string              `yaml:"restart,omitempty"`
-	Resources       map[string]string   `yaml:"resources,omitempty"`
-	Networks        []string            `yaml:"networks,omitempty"`
-	CapAdd          []string            `yaml:"cap_add,omitempty"`
-	CapDrop         []string            `yaml:"cap_drop,omitempty"`
-	Privileged      bool                `yaml:"privileged,omitempty"`
-	Healthcheck     *ComposeHealthcheck `yaml:"healthcheck,omitempty"`
-	Tty             bool                `yaml:"tty,omitempty"`
-	User            string              `yaml:"user,omitempty"`
-	Cmd             []string            `yaml:"command,omitempty"`
-	Entrypoint      []string            `yaml:"entrypoint,omitempty"`
-	Labels          map[string]string   `yaml:"labels,omitempty"`
-	Hostname        string              `yaml:"hostname,omitempty"`
-	Domainname      string              `yaml:"domainname,omitempty"`
-	OpenStdin       bool                `yaml:"open_stdin,omitempty"`
-	StdinOnce       bool                `yaml:"stdin_once,omitempty"`
-	WorkingDir      string              `yaml:"working_dir,omitempty"`
-	NetworkDisabled bool                `yaml:"network_disabled,omitempty"`
-	StopSignal      string              `yaml:"stop_signal,omitempty"`
-	StopTimeout     *int                `yaml:"stop_timeout,omitempty"`
-	Shell           []string            `yaml:"shell,omitempty"`
-	Dns             []string            `yaml:"dns,omitempty"`
-	DnsSearch       []string            `yaml:"dns_search,omitempty"`
-	DnsOptions      []string            `yaml:"dns_opt,omitempty"`
-}
+// version is the release of the tool, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
 
-type ComposeHealthcheck struct {
-	Test        []string      `yaml:"test,omitempty"`
-	Interval    time.Duration `yaml:"interval,omitempty"`
-	Timeout     time.Duration `yaml:"timeout,omitempty"`
-	Retries     int           `yaml:"retries,omitempty"`
-	StartPeriod time.Duration `yaml:"start_period,omitempty"`
-}
+func main() {
+	opts, args, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(exitCode(withKind(errUsage, err)))
+	}
 
-type ComposeVolume struct {
-	External bool   `yaml:"external,omitempty"`
-	Name     string `yaml:"name,omitempty"`
-}
+	quiet, verbose = opts.Quiet, opts.Verbose
 
-type ComposeFile struct {
-	Services map[string]ComposeService `yaml:"services"`
-	Volumes  map[string]ComposeVolume  `yaml:"volumes,omitempty"`
-}
+	// Interrupting cancels the API calls in flight, nothing is written then
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func main() {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	dockerClient, err := newClient(ctx, opts.Host, opts.Timeout)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating Docker client: %v\n", err)
-		os.Exit(1)
+		fail(interrupted(ctx, withKind(errDaemon, err)))
+	}
+	defer dockerClient.Close()
+	var cli autocompose.Client = timeoutClient{dockerClient, opts.Timeout}
+	if verbose {
+		cli = loggingClient{cli}
 	}
-	defer cli.Close()
 
-	if len(os.Args) < 2 {
-		// List all containers
-		containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error listing containers: %v\n", err)
-			os.Exit(1)
+	if opts.Engine == "" {
+		opts.Engine = autocompose.DetectEngine(ctx, cli)
+	}
+
+	if len(opts.Services) > 0 {
+		compose, err := exportSwarmServices(ctx, cli, opts.Services, opts)
+		if err = interrupted(ctx, err); errors.Is(err, errInterrupted) {
+			fail(err)
 		}
+		if len(compose.Services) > 0 {
+			writeCompose(compose, opts.Output, opts)
+		}
+		os.Exit(exitCode(err))
+	}
 
-		fmt.Println("CONTAINER ID\tNAMES")
-		for _, container := range containers {
-			names := make([]string, len(container.Names))
-			for i, name := range container.Names {
-				names[i] = strings.TrimPrefix(name, "/")
-			}
-			fmt.Printf("%s\t%s\n", container.ID[:12], strings.Join(names, ", "))
+	if opts.ExportAll {
+		compose, err := exportAllContainers(ctx, cli, opts)
+		if err = interrupted(ctx, err); err != nil {
+			fail(err)
 		}
+		writeCompose(compose, opts.Output, opts)
 		return
 	}
 
-	containerID := os.Args[1]
-	var outputFile string
-
-	if len(os.Args) > 2 {
-		outputFile = os.Args[2]
+	if len(args) < 1 && !opts.Interactive {
+		// List all containers
+		if err := printContainerList(ctx, cli, opts); err != nil {
+			fail(interrupted(ctx, err))
+		}
+		return
 	}
 
-	containerJSON, err := cli.ContainerInspect(ctx, containerID)
+	containerIDs, err := expandContainerArgs(ctx, cli, args, opts.Regex)
+	if err == nil && opts.Interactive {
+		var picked []string
+		picked, err = pickContainers(ctx, cli, opts)
+		containerIDs = append(containerIDs, picked...)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error inspecting container %s: %v\n", containerID, err)
-		os.Exit(1)
+		fail(interrupted(ctx, err))
 	}
 
-	imageJSON, err := cli.ImageInspect(ctx, containerJSON.Image)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error inspecting image %s: %v\n", containerJSON.Config.Image, err)
-		os.Exit(1)
+	compose, err := exportContainers(ctx, cli, containerIDs, opts)
+	if err = interrupted(ctx, err); errors.Is(err, errInterrupted) {
+		fail(err)
+	}
+	if len(compose.Services) > 0 {
+		writeCompose(compose, opts.Output, opts)
 	}
+	os.Exit(exitCode(err))
+}
 
-	compose := generateCompose(cli, containerJSON, imageJSON)
+// interrupted replaces err with errInterrupted when ctx was cancelled by a
+// signal, since the failure is then only a consequence of it.
+func interrupted(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return withKind(errInterrupted, fmt.Errorf("Interrupted, nothing was written"))
+	}
+	return err
+}
 
-	yamlData, err := yaml.Marshal(compose)
+// exportContainers generates the compose file of the given containers,
+// reporting the warnings and the containers that could not be exported.
+func exportContainers(ctx context.Context, cli autocompose.Client, containerIDs []string, opts Options) (autocompose.ComposeFile, error) {
+	compose, warnings, err := autocompose.Generate(ctx, cli, containerIDs, opts.Options)
+	report(compose, warnings)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error marshalling YAML: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
+	return compose, inspectError(err)
+}
 
-	if outputFile != "" {
-		err = os.WriteFile(outputFile, yamlData, 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing to file %s: %v\n", outputFile, err)
-			os.Exit(1)
+// exportSwarmServices generates the compose file of the named swarm services,
+// reporting the warnings and the services that could not be exported.
+func exportSwarmServices(ctx context.Context, cli autocompose.Client, names []string, opts Options) (autocompose.ComposeFile, error) {
+	compose, warnings, err := autocompose.GenerateServices(ctx, cli, names, opts.Options)
+	report(compose, warnings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	return compose, inspectError(err)
+}
+
+// report prints the warnings of a generation and, when verbose, the values it
+// derived or left out.
+func report(compose autocompose.ComposeFile, warnings []autocompose.Warning) {
+	for _, warning := range warnings {
+		warnf("%s", warning)
+	}
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, note := range compose.Services[name].Notes() {
+			debugf("%s: %s", name, note)
 		}
-		fmt.Printf("Compose file written to %s\n", outputFile)
-	} else {
-		fmt.Println(string(yamlData))
 	}
 }
 
-func generateCompose(cli *client.Client, containerJSON container.InspectResponse, imageJSON image.InspectResponse) ComposeFile {
-	compose := ComposeFile{
-		Services: make(map[string]ComposeService),
-		Volumes:  make(map[string]ComposeVolume),
-	}
-
-	service := ComposeService{
-		Image:           containerJSON.Config.Image,
-		Ports:           make([]string, 0),
-		Volumes:         make([]string, 0),
-		ContainerName:   containerJSON.Name[1:], // Remove leading '/'
-		Dns:             containerJSON.HostConfig.DNS,
-		DnsSearch:       containerJSON.HostConfig.DNSSearch,
-		DnsOptions:      containerJSON.HostConfig.DNSOptions,
-		Environment:     make(map[string]string),
-		Restart:         string(containerJSON.HostConfig.RestartPolicy.Name),
-		Resources:       make(map[string]string),
-		Networks:        make([]string, 0),
-		CapAdd:          containerJSON.HostConfig.CapAdd,
-		CapDrop:         containerJSON.HostConfig.CapDrop,
-		Privileged:      containerJSON.HostConfig.Privileged,
-		Healthcheck:     nil,
-		Tty:             containerJSON.Config.Tty,
-		User:            containerJSON.Config.User,
-		Cmd:             nil,
-		Entrypoint:      nil,
-		Labels:          make(map[string]string),
-		Hostname:        "",
-		Domainname:      containerJSON.Config.Domainname,
-		OpenStdin:       containerJSON.Config.OpenStdin,
-		StdinOnce:       containerJSON.Config.StdinOnce,
-		WorkingDir:      "",
-		NetworkDisabled: containerJSON.Config.NetworkDisabled,
-		StopSignal:      containerJSON.Config.StopSignal,
-		StopTimeout:     containerJSON.Config.StopTimeout,
-		Shell:           containerJSON.Config.Shell,
-	}
-
-	for p, bindings := range containerJSON.HostConfig.PortBindings {
-		for _, binding := range bindings {
-			portMapping := binding.HostPort + ":" + p.Port()
-			if binding.HostIP != "" && binding.HostIP != "0.0.0.0" {
-				portMapping = binding.HostIP + ":" + binding.HostPort + ":" + p.Port()
+// expandContainerArgs replaces container arguments that are glob patterns, or
+// regular expressions with useRegex, by the IDs of the containers whose names
+// match. Plain names and IDs are passed through unchanged.
+func expandContainerArgs(ctx context.Context, cli autocompose.Client, args []string, useRegex bool) ([]string, error) {
+	var containers []container.Summary
+	listed := false
+
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		if !useRegex && !strings.ContainsAny(arg, "*?[") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		var pattern *regexp.Regexp
+		if useRegex {
+			var err error
+			if pattern, err = regexp.Compile(arg); err != nil {
+				return nil, withKind(errUsage, fmt.Errorf("Invalid regular expression %q: %v", arg, err))
 			}
-			if p.Proto() == "udp" {
-				portMapping += "/udp"
+		} else if _, err := path.Match(arg, ""); err != nil {
+			return nil, withKind(errUsage, fmt.Errorf("Invalid pattern %q: %v", arg, err))
+		}
+
+		if !listed {
+			var err error
+			if containers, err = cli.ContainerList(ctx, container.ListOptions{All: true}); err != nil {
+				return nil, fmt.Errorf("Error listing containers: %w", err)
 			}
-			service.Ports = append(service.Ports, portMapping)
+			listed = true
 		}
-	}
 
-	// Volume mapping distinction
-	for _, mount := range containerJSON.Mounts {
-		volumeMapping := mount.Source + ":" + mount.Destination
-		if mount.Type == "volume" {
-			// Docker volume
-			service.Volumes = append(service.Volumes, mount.Name+":"+mount.Destination)
-			volumeInspect, err := cli.VolumeInspect(context.Background(), mount.Name)
-			compose.Volumes[mount.Name] = ComposeVolume{
-				Name:     mount.Name,
-				External: err != nil || !isComposeVolume(volumeInspect),
+		matched := 0
+		for _, c := range containers {
+			for _, name := range c.Names {
+				name = strings.TrimPrefix(name, "/")
+				var matches bool
+				if pattern != nil {
+					matches = pattern.MatchString(name)
+				} else {
+					matches, _ = path.Match(arg, name)
+				}
+				if matches {
+					matched++
+					if !seen[c.ID] {
+						seen[c.ID] = true
+						expanded = append(expanded, c.ID)
+					}
+					break
+				}
 			}
-		} else if mount.Type == "bind" {
-			// Local folder
-			service.Volumes = append(service.Volumes, volumeMapping)
+		}
+		if matched == 0 {
+			return nil, withKind(errNotFound, fmt.Errorf("No container matches %q", arg))
 		}
 	}
+	return expanded, nil
+}
 
-	containerEnv := parseEnv(containerJSON.Config.Env)
-	imageEnv := parseEnv(imageJSON.Config.Env)
+// newClient connects to the daemon at host, falling back to DOCKER_HOST and the
+// local socket. ssh:// hosts are reached by running "docker system dial-stdio"
+// on the remote machine over ssh.
+func newClient(ctx context.Context, host string, timeout time.Duration) (*client.Client, error) {
+	if host == "" {
+		host = os.Getenv(client.EnvOverrideHost)
+	}
 
-	for key, value := range containerEnv {
-		if imageEnv[key] != value {
-			service.Environment[key] = value
+	clientOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if host != "" {
+		helper, err := connhelper.GetConnectionHelper(host)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Docker host %s: %v", host, err)
+		}
+		if helper != nil {
+			clientOpts = append(clientOpts,
+				client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: helper.Dialer}}),
+				client.WithHost(helper.Host),
+				client.WithDialContext(helper.Dialer),
+			)
+		} else {
+			clientOpts = append(clientOpts, client.WithHost(host))
 		}
 	}
 
-	if containerJSON.HostConfig.CPUPeriod > 0 {
-		service.Resources["cpus"] = fmt.Sprintf("%.2f", float64(containerJSON.HostConfig.CPUQuota)/float64(containerJSON.HostConfig.CPUPeriod))
+	cli, err := client.NewClientWithOpts(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Docker client: %v", err)
 	}
+	if _, err := withTimeout(ctx, timeout, "ping", cli.Ping); err != nil {
+		cli.Close()
+		if client.IsErrConnectionFailed(err) {
+			// Already names the host and suggests checking the daemon
+			return nil, err
+		}
+		if host == "" {
+			host = cli.DaemonHost()
+		}
+		return nil, fmt.Errorf("Cannot connect to the Docker daemon at %s: %v", host, err)
+	}
+	return cli, nil
+}
 
-	if containerJSON.HostConfig.Memory > 0 {
-		service.Resources["mem_limit"] = strconv.FormatInt(containerJSON.HostConfig.Memory, 10)
+func writeCompose(compose autocompose.ComposeFile, outputFile string, opts Options) {
+	compose.Version = opts.Version
+	compose.ExcludeFields(opts.ExcludeFields)
+	if opts.PullPolicy != "" {
+		for name, service := range compose.Services {
+			service.PullPolicy = opts.PullPolicy
+			compose.Services[name] = service
+		}
 	}
 
-	// Network filtering
-	for networkName := range containerJSON.NetworkSettings.Networks {
-		if !isComposeNetwork(networkName) && !isBuiltInNetwork(networkName) {
-			service.Networks = append(service.Networks, networkName)
+	if opts.Diff != "" {
+		drifted, err := diffCompose(compose, opts.Diff, os.Stdout)
+		if err != nil {
+			fail(err)
+		}
+		if drifted {
+			os.Exit(1)
 		}
+		return
 	}
 
-	// Healthcheck comparison
-	if containerJSON.Config.Healthcheck != nil {
-		if imageJSON.Config.Healthcheck == nil || !healthchecksEqual(containerJSON.Config.Healthcheck, imageJSON.Config.Healthcheck) {
-			service.Healthcheck = &ComposeHealthcheck{
-				Test:        containerJSON.Config.Healthcheck.Test,
-				Interval:    time.Duration(containerJSON.Config.Healthcheck.Interval),
-				Timeout:     time.Duration(containerJSON.Config.Healthcheck.Timeout),
-				Retries:     int(containerJSON.Config.Healthcheck.Retries),
-				StartPeriod: time.Duration(containerJSON.Config.Healthcheck.StartPeriod),
-			}
+	if opts.Anonymize {
+		anonymize(&compose)
+	}
+
+	// values moved out of the file into ${VARIABLES} and written to .env
+	var dotenvVars, masked map[string]string
+	if opts.MaskSecrets {
+		masked = maskSecrets(&compose, opts.SecretKeys)
+		if !opts.Redact {
+			dotenvVars = maps.Clone(masked)
 		}
 	}
+	if len(opts.Parameterize) > 0 {
+		params := parameterize(&compose, opts.Parameterize)
+		if dotenvVars == nil {
+			dotenvVars = params
+		} else {
+			maps.Copy(dotenvVars, params)
+		}
+	}
+
+	var envFiles map[string]string
+	if opts.EnvFileOut != "" {
+		envFiles = extractEnvFiles(&compose, opts.EnvFileOut, outputFile, masked)
+	}
+
+	var yamlData []byte
+	doc, err := compose.Node(autocompose.MarshalOptions{
+		EnvList:   opts.EnvList,
+		Annotate:  opts.Annotate,
+		Dedupe:    opts.Dedupe,
+		Metadata:  opts.Metadata,
+		Generator: "docker-autocompose " + version,
+	})
+	if err == nil {
+		yamlData, err = yaml.Marshal(doc)
+	}
+	if err != nil {
+		fail(fmt.Errorf("Error marshalling YAML: %v", err))
+	}
 
-	// Label comparison
-	for key, value := range containerJSON.Config.Labels {
-		if imageJSON.Config.Labels[key] != value && !strings.HasPrefix(key, "com.docker.compose") {
-			service.Labels[key] = value
+	merged := false
+	if opts.Merge {
+		mergedData, err := mergeComposeFile(doc, outputFile, opts.ReplaceServices)
+		if err != nil {
+			fail(err)
+		}
+		if mergedData != nil {
+			yamlData, merged = mergedData, true
 		}
 	}
 
-	// Entrypoint comparison
-	if !strSlicesEqual(containerJSON.Config.Entrypoint, imageJSON.Config.Entrypoint) {
-		service.Entrypoint = containerJSON.Config.Entrypoint
+	if !opts.NoValidate {
+		if err := validateCompose(yamlData, filepath.Dir(outputFile)); err != nil {
+			fail(fmt.Errorf("Generated compose file is invalid: %v", err))
+		}
 	}
 
-	// Cmd comparison
-	if !strSlicesEqual(containerJSON.Config.Cmd, imageJSON.Config.Cmd) {
-		service.Cmd = containerJSON.Config.Cmd
+	if len(dotenvVars) > 0 {
+		// Compose reads .env from the project directory for interpolation
+		dotenv := filepath.Join(filepath.Dir(outputFile), ".env")
+		if err := mergeDotenv(dotenv, dotenvVars); err != nil {
+			fail(withKind(errWrite, fmt.Errorf("Error writing %s: %v", dotenv, err)))
+		}
 	}
 
-	// WorkingDir comparison
-	if containerJSON.Config.WorkingDir != imageJSON.Config.WorkingDir {
-		service.WorkingDir = containerJSON.Config.WorkingDir
+	for path, content := range envFiles {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = writeFile(path, []byte(content), 0600)
+		}
+		if err != nil {
+			fail(withKind(errWrite, fmt.Errorf("Error writing env file %s: %v", path, err)))
+		}
 	}
 
-	// Hostname comparison
-	if containerJSON.Config.Hostname != "" && !isRandomHostname(containerJSON.Config.Hostname, containerJSON.ID) {
-		service.Hostname = containerJSON.Config.Hostname
+	// The header of a merged file would only describe part of it
+	if !opts.NoHeader && !merged {
+		yamlData = append([]byte(compose.Header("docker-autocompose "+version, time.Now())), yamlData...)
 	}
 
-	compose.Services[containerJSON.Name[1:]] = service
-	return compose
+	if outputFile != "" {
+		err = writeFile(outputFile, yamlData, 0644)
+		if err != nil {
+			fail(withKind(errWrite, fmt.Errorf("Error writing to file %s: %v", outputFile, err)))
+		}
+		infof("Compose file written to %s", outputFile)
+	} else {
+		fmt.Println(string(yamlData))
+	}
+}
+
+// validateCompose loads the generated document with the compose-go loader so
+// that anything docker compose would reject is caught before it is written.
+func validateCompose(yamlData []byte, workingDir string) error {
+	details := types.ConfigDetails{
+		WorkingDir:  workingDir,
+		ConfigFiles: []types.ConfigFile{{Filename: "compose.yml", Content: yamlData}},
+		Environment: types.Mapping{},
+	}
+	_, err := loader.LoadModelWithContext(context.Background(), details, func(o *loader.Options) {
+		o.SetProjectName("autocompose", false)
+		// Referenced env files and ${VARIABLES} may not exist yet
+		o.SkipInterpolation = true
+		o.SkipResolveEnvironment = true
+	})
+	return err
 }
 
-func healthchecksEqual(a, b *container.HealthConfig) bool {
-	if len(a.Test) != len(b.Test) || a.Interval != b.Interval || a.Timeout != b.Timeout || a.Retries != b.Retries || a.StartPeriod != b.StartPeriod {
-		return false
+// extractEnvFiles moves each service's environment into a dotenv file and points
+// env_file at it, returning the file contents by path. A single service uses
+// path. When path is a directory (or ends in a separator) each service gets
+// <service>.env inside it, and with several services otherwise <service>.env
+// next to it, so that path itself may well be the .env file. Values referencing
+// one of the masked variables stay in environment, where compose interpolates
+// them for sure.
+func extractEnvFiles(compose *autocompose.ComposeFile, path, outputFile string, masked map[string]string) map[string]string {
+	dir := ""
+	if stat, err := os.Stat(path); (err == nil && stat.IsDir()) || strings.HasSuffix(path, string(filepath.Separator)) || strings.HasSuffix(path, "/") {
+		dir = path
+	} else if len(compose.Services) > 1 {
+		dir = filepath.Dir(path)
 	}
-	for i, v := range a.Test {
-		if v != b.Test[i] {
-			return false
+
+	files := make(map[string]string)
+	for name, service := range compose.Services {
+		extracted := make(map[string]string)
+		inline := make(autocompose.StringMap)
+		for key, value := range service.Environment {
+			variable, isReference := strings.CutPrefix(value, "${")
+			if _, isMasked := masked[strings.TrimSuffix(variable, "}")]; isReference && isMasked {
+				inline[key] = value
+			} else {
+				extracted[key] = unescapeDollars(value)
+			}
+		}
+		if len(extracted) == 0 {
+			continue
 		}
+		envPath := path
+		if dir != "" {
+			envPath = filepath.Join(dir, name+".env")
+		}
+		files[envPath] = formatDotenv(extracted)
+
+		// env_file is resolved relative to the compose file
+		ref := envPath
+		if outputFile != "" {
+			if rel, err := filepath.Rel(filepath.Dir(outputFile), envPath); err == nil {
+				ref = rel
+			}
+		}
+		service.EnvFile = []string{ref}
+		service.Environment = nil
+		if len(inline) > 0 {
+			service.Environment = inline
+		}
+		compose.Services[name] = service
 	}
-	return true
+	return files
 }
 
-func isComposeVolume(volumeInspect volume.Volume) bool {
-	for key := range volumeInspect.Labels {
-		if strings.HasPrefix(key, "com.docker.compose.") {
+var defaultSecretKeys = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH"}
+
+// maskSecrets replaces environment values whose key contains one of the
+// patterns with a ${SERVICE_KEY} reference and returns the real values.
+func maskSecrets(compose *autocompose.ComposeFile, patterns []string) map[string]string {
+	secrets := make(map[string]string)
+	for name, service := range compose.Services {
+		for key, value := range service.Environment {
+			if !isSecretKey(key, patterns) || value == "" {
+				continue
+			}
+			variable := envVariableName(name + "_" + key)
+			secrets[variable] = unescapeDollars(value)
+			service.Environment[key] = "${" + variable + "}"
+		}
+	}
+	return secrets
+}
+
+// unescapeDollars undoes the $$ escaping of a compose value for a dotenv
+// file, which quoteDotenv escapes in its own way.
+func unescapeDollars(value string) string {
+	return strings.ReplaceAll(value, "$$", "$")
+}
+
+func isSecretKey(key string, patterns []string) bool {
+	key = strings.ToUpper(key)
+	for _, pattern := range patterns {
+		if pattern = strings.ToUpper(strings.TrimSpace(pattern)); pattern != "" && strings.Contains(key, pattern) {
 			return true
 		}
 	}
 	return false
 }
 
-func isBuiltInNetwork(networkName string) bool {
-	return networkName == "bridge" || networkName == "host" || networkName == "none"
+// envVariableName upper-cases s and replaces everything that is not valid in a
+// variable name with an underscore.
+func envVariableName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, s)
 }
 
-func isComposeNetwork(networkName string) bool {
-	return strings.Contains(networkName, "_default") || strings.Contains(networkName, "_")
+// mergeDotenv writes vars into the dotenv file at path, keeping any existing
+// lines that set other variables.
+func mergeDotenv(path string, vars map[string]string) error {
+	var kept []string
+	if existing, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(strings.TrimRight(string(existing), "\n"), "\n") {
+			key, _, _ := strings.Cut(strings.TrimSpace(line), "=")
+			if _, replaced := vars[key]; !replaced {
+				kept = append(kept, line)
+			}
+		}
+	}
+	content := formatDotenv(vars)
+	if len(kept) > 0 {
+		content = strings.Join(kept, "\n") + "\n" + content
+	}
+	return writeFile(path, []byte(content), 0600)
 }
 
-func isRandomHostname(hostname, containerID string) bool {
-	return len(hostname) == 12 && containerID != "" && containerID != hostname && containerID[:12] == hostname
+// writeFile writes data to a temporary file next to path and renames it into
+// place, so that an interrupted run never leaves a partial file behind.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-func strSlicesEqual(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
+// formatDotenv renders vars as sorted KEY=value lines.
+func formatDotenv(vars map[string]string) string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var content strings.Builder
+	for _, key := range keys {
+		content.WriteString(key + "=" + quoteDotenv(vars[key]) + "\n")
+	}
+	return content.String()
+}
+
+// quoteDotenv quotes a value for a dotenv file when it contains anything the
+// parser would otherwise interpret: whitespace, comments, quotes or variables.
+func quoteDotenv(value string) string {
+	if value == "" || !strings.ContainsAny(value, " \t\r\n#'\"$\\=") {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		// Single quotes are taken literally
+		return "'" + value + "'"
 	}
-	for i, v := range a {
-		if v != b[i] {
-			return false
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// exportAllContainers inspects every container on the host and merges them into a
+// single compose file. Containers that fail inspection are skipped with a warning.
+func exportAllContainers(ctx context.Context, cli autocompose.Client, opts Options) (autocompose.ComposeFile, error) {
+	containers, err := listContainers(ctx, cli, opts, "running")
+	if err != nil {
+		return autocompose.ComposeFile{}, err
+	}
+
+	containerIDs := make([]string, len(containers))
+	for i, c := range containers {
+		containerIDs[i] = c.ID
+	}
+	compose, warnings, err := autocompose.Generate(ctx, cli, containerIDs, opts.Options)
+	if ctx.Err() != nil {
+		// Every container left is failing because of the interrupt
+		return compose, ctx.Err()
+	}
+	report(compose, warnings)
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			warnf("skipping container: %v", err)
+		}
+	}
+
+	if len(compose.Services) == 0 {
+		return compose, fmt.Errorf("No containers could be exported")
+	}
+	return compose, nil
+}
+
+// listContainers lists the containers matching the --filter and --status
+// options, using defaultStatus when no state was asked for.
+func listContainers(ctx context.Context, cli autocompose.Client, opts Options, defaultStatus string) ([]container.Summary, error) {
+	listFilters, err := parseFilters(opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// The daemon has no image filter, those are matched against Config.Image here
+	imageNames := listFilters.Get("image")
+	for _, name := range imageNames {
+		listFilters.Del("image", name)
+	}
+	// Resolve ancestors to image IDs so children built from them match too
+	for _, ancestor := range listFilters.Get("ancestor") {
+		if imageJSON, err := cli.ImageInspect(ctx, ancestor); err == nil {
+			listFilters.Add("ancestor", imageJSON.ID)
+		}
+	}
+
+	for _, status := range statusFilters(opts.Statuses, listFilters, defaultStatus) {
+		listFilters.Add("status", status)
+	}
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: listFilters})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing containers: %w", err)
+	}
+
+	if len(imageNames) == 0 {
+		return containers, nil
+	}
+	var matching []container.Summary
+	for _, c := range containers {
+		if matchesImage(c.Image, imageNames) {
+			matching = append(matching, c)
 		}
 	}
-	return true
+	return matching, nil
 }
 
-func parseEnv(envVars []string) map[string]string {
-	envMap := make(map[string]string)
-	for _, env := range envVars {
-		parts := stringParts(env, "=")
-		if len(parts) == 2 {
-			envMap[parts[0]] = parts[1]
+// parseFilters turns "key=value" flags into list filters. Values for the same
+// key are OR-ed and different keys AND-ed by the daemon, as with docker ps.
+func parseFilters(flags []string) (filters.Args, error) {
+	args := filters.NewArgs()
+	for _, flag := range flags {
+		key, value, ok := strings.Cut(flag, "=")
+		if !ok || key == "" {
+			return args, withKind(errUsage, fmt.Errorf("Invalid filter %q, expected key=value", flag))
 		}
+		args.Add(key, value)
 	}
-	return envMap
+	return args, nil
 }
 
-func stringParts(s, sep string) []string {
-	idx := -1
-	for i := 0; i < len(s); i++ {
-		if string(s[i]) == sep {
-			idx = i
-			break
+// statusFilters expands the requested container states into status filter
+// values. Restarting containers count as running and dead ones as exited, and
+// "all" disables the filter. Without any state, either from --status or a
+// status filter, defaultStatus applies.
+func statusFilters(statuses []string, listFilters filters.Args, defaultStatus string) []string {
+	if len(statuses) == 0 {
+		if listFilters.Contains("status") {
+			return nil
+		}
+		statuses = []string{defaultStatus}
+	}
+
+	var expanded []string
+	for _, status := range statuses {
+		switch status {
+		case "all":
+			return nil
+		case "running":
+			expanded = append(expanded, "running", "restarting")
+		case "exited":
+			expanded = append(expanded, "exited", "dead")
+		default:
+			expanded = append(expanded, status)
 		}
 	}
-	if idx == -1 {
-		return []string{s}
+	return expanded
+}
+
+// matchesImage reports whether imageName refers to one of names, treating
+// "nginx", "nginx:latest" and "docker.io/library/nginx:latest" as equal.
+func matchesImage(imageName string, names []string) bool {
+	normalized := normalizeImageName(imageName)
+	for _, name := range names {
+		if normalizeImageName(name) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeImageName(name string) string {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
 	}
-	return []string{s[:idx], s[idx+1:]}
+	return reference.TagNameOnly(named).String()
 }
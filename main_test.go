@@ -0,0 +1,102 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"docker-autocompose/autocompose"
+)
+
+func TestExtractEnvFilesKeepsMaskedReferences(t *testing.T) {
+	compose := autocompose.ComposeFile{Services: map[string]autocompose.ComposeService{
+		"web": {Image: "web", Environment: autocompose.StringMap{"WEB_PASSWORD": "hunter2", "MODE": "prod"}},
+	}}
+	masked := maskSecrets(&compose, defaultSecretKeys)
+	path := filepath.Join(t.TempDir(), "web.env")
+	files := extractEnvFiles(&compose, path, "", masked)
+
+	if content := files[path]; content != "MODE=prod\n" {
+		t.Errorf("env file = %q, want only MODE", content)
+	}
+	service := compose.Services["web"]
+	if got := service.Environment["WEB_PASSWORD"]; got != "${WEB_WEB_PASSWORD}" {
+		t.Errorf("environment WEB_PASSWORD = %q, want the masked reference", got)
+	}
+	for _, content := range files {
+		if strings.Contains(content, "${") {
+			t.Errorf("env file holds a reference compose would not interpolate: %q", content)
+		}
+	}
+}
+
+func TestExtractEnvFilesPaths(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		path     string
+		services []string
+		want     []string
+	}{
+		{"single service", filepath.Join(dir, "app.env"), []string{"web"}, []string{filepath.Join(dir, "app.env")}},
+		{"several services next to the file", filepath.Join(dir, ".env"), []string{"web", "db"}, []string{filepath.Join(dir, "db.env"), filepath.Join(dir, "web.env")}},
+		{"existing directory", dir, []string{"web"}, []string{filepath.Join(dir, "web.env")}},
+		{"trailing separator", filepath.Join(dir, "env") + string(filepath.Separator), []string{"web", "db"}, []string{filepath.Join(dir, "env", "db.env"), filepath.Join(dir, "env", "web.env")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compose := autocompose.ComposeFile{Services: map[string]autocompose.ComposeService{}}
+			for _, name := range tt.services {
+				compose.Services[name] = autocompose.ComposeService{Image: name, Environment: autocompose.StringMap{"MODE": "prod"}}
+			}
+			files := extractEnvFiles(&compose, tt.path, "", nil)
+			got := slices.Sorted(maps.Keys(files))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("env files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvFilesUnescapeDollars(t *testing.T) {
+	compose := autocompose.ComposeFile{Services: map[string]autocompose.ComposeService{
+		"web": {Image: "web", Environment: autocompose.StringMap{"DB_PASSWORD": "pa$$word", "PRICE": "$$5"}},
+	}}
+	masked := maskSecrets(&compose, defaultSecretKeys)
+	if got := masked["WEB_DB_PASSWORD"]; got != "pa$word" {
+		t.Errorf("masked value = %q, want pa$word", got)
+	}
+	path := filepath.Join(t.TempDir(), "web.env")
+	files := extractEnvFiles(&compose, path, "", masked)
+	if content := files[path]; content != "PRICE='$5'\n" {
+		t.Errorf("env file = %q, want PRICE='$5'", content)
+	}
+}
+
+func TestParameterizeBinds(t *testing.T) {
+	compose := autocompose.ComposeFile{Services: map[string]autocompose.ComposeService{
+		"web": {Image: "web", Volumes: []autocompose.ComposeServiceVolume{{Short: "/srv/data/web:/data"}, {Short: "/opt/data/cache:/cache"}}},
+		"db":  {Image: "db", Volumes: []autocompose.ComposeServiceVolume{{Short: "/srv/data/db:/var/lib/db"}, {Short: "/backup:/backup:ro"}}},
+	}}
+	vars := parameterize(&compose, []string{"binds"})
+
+	want := map[string][]string{
+		"web": {"${WEB_DATA_DIR}/web:/data", "${WEB_DATA_DIR_2}/cache:/cache"},
+		"db":  {"${DB_DATA_DIR}/db:/var/lib/db", "${DB_BACKUP_DIR}:/backup:ro"},
+	}
+	for name, volumes := range want {
+		var got []string
+		for _, volume := range compose.Services[name].Volumes {
+			got = append(got, volume.Short)
+		}
+		if !slices.Equal(got, volumes) {
+			t.Errorf("%s volumes = %q, want %q", name, got, volumes)
+		}
+	}
+	wantVars := map[string]string{"WEB_DATA_DIR": "/srv/data", "WEB_DATA_DIR_2": "/opt/data", "DB_DATA_DIR": "/srv/data", "DB_BACKUP_DIR": "/backup"}
+	if !maps.Equal(vars, wantVars) {
+		t.Errorf("variables = %v, want %v", vars, wantVars)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mergeComposeFile adds the services of the generated document to the compose
+// file at path, along with the volumes, networks, secrets and configs they
+// need that the file does not declare yet. Everything else in the file is kept
+// as it is, comments included. A service that already exists is replaced if
+// replace is set and an error otherwise. It returns nil if the file does not
+// exist yet.
+func mergeComposeFile(doc *yaml.Node, path string, replace bool) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, withKind(errWrite, fmt.Errorf("Error reading %s: %v", path, err))
+	}
+
+	var existing yaml.Node
+	if err := yaml.Unmarshal(data, &existing); err != nil {
+		return nil, withKind(errUsage, fmt.Errorf("Error parsing %s: %v", path, err))
+	}
+	if len(existing.Content) == 0 {
+		// Empty file
+		return nil, nil
+	}
+	root := existing.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, withKind(errUsage, fmt.Errorf("Error parsing %s: not a compose file", path))
+	}
+
+	for _, section := range []string{"services", "volumes", "networks", "secrets", "configs"} {
+		generated := mappingValue(doc, section)
+		if generated == nil {
+			continue
+		}
+		target := mappingValue(root, section)
+		if target == nil || target.Kind != yaml.MappingNode {
+			target = &yaml.Node{Kind: yaml.MappingNode}
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, target)
+		}
+		for i := 0; i+1 < len(generated.Content); i += 2 {
+			key, value := generated.Content[i], generated.Content[i+1]
+			existingValue := mappingValue(target, key.Value)
+			switch {
+			case existingValue == nil:
+				target.Content = append(target.Content, key, value)
+			case section != "services":
+				// Keep the file's own definition
+			case replace:
+				*existingValue = *value
+			default:
+				return nil, withKind(errUsage, fmt.Errorf("Service %s already exists in %s, use --replace-service to replace it", key.Value, path))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(detectIndent(data))
+	if err := encoder.Encode(&existing); err != nil {
+		return nil, fmt.Errorf("Error marshalling YAML: %v", err)
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
+
+// detectIndent returns the indentation of the first nested line of a YAML
+// document, or the yaml.v3 default of 4.
+func detectIndent(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if indent := len(line) - len(trimmed); indent > 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return indent
+		}
+	}
+	return 4
+}
+
+// mappingValue returns the value node of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
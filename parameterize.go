@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"docker-autocompose/autocompose"
+)
+
+// parameterize replaces the host ports and the bind mount source directories
+// of every service, as selected by kinds, with ${VARIABLES} and returns their
+// current values. Names come from the service and the port or directory, and
+// the same value is given the same variable across services.
+func parameterize(compose *autocompose.ComposeFile, kinds []string) map[string]string {
+	vars := make(map[string]string)
+	// variable names value after base, adding a counter when base already
+	// holds a different value
+	variable := func(base, value string) string {
+		name := envVariableName(base)
+		for i := 2; ; i++ {
+			if existing, ok := vars[name]; !ok || existing == value {
+				vars[name] = value
+				return "${" + name + "}"
+			}
+			name = envVariableName(base + "_" + strconv.Itoa(i))
+		}
+	}
+
+	// visit services in order so that the numbering is stable
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		service := compose.Services[name]
+		if slices.Contains(kinds, "ports") {
+			for i, port := range service.Ports {
+				if port.Long != nil {
+					if port.Long.Published != "" {
+						base := fmt.Sprintf("%s_PORT_%d", name, port.Long.Target)
+						if port.Long.Protocol != "" && port.Long.Protocol != "tcp" {
+							base += "_" + port.Long.Protocol
+						}
+						port.Long.Published = variable(base, port.Long.Published)
+					}
+				} else if prefix, published, target, ok := splitPublishedPort(port.Short); ok {
+					port.Short = prefix + variable(name+"_PORT_"+target, published) + ":" + target
+				}
+				service.Ports[i] = port
+			}
+		}
+		if slices.Contains(kinds, "binds") {
+			for i, volume := range service.Volumes {
+				if volume.Long != nil {
+					if volume.Long.Type == "bind" {
+						volume.Long.Source = parameterizeBind(name, volume.Long.Source, variable)
+					}
+				} else if source, rest, ok := strings.Cut(volume.Short, ":"); ok {
+					volume.Short = parameterizeBind(name, source, variable) + ":" + rest
+				}
+				service.Volumes[i] = volume
+			}
+		}
+		compose.Services[name] = service
+	}
+	return vars
+}
+
+// splitPublishedPort splits a short port mapping such as "127.0.0.1:8080:80/udp"
+// into what precedes the host port, the host port and the container port. ok
+// is false when no host port is given.
+func splitPublishedPort(mapping string) (prefix, published, target string, ok bool) {
+	i := strings.LastIndex(mapping, ":")
+	if i < 0 {
+		return "", "", "", false
+	}
+	host, target := mapping[:i], mapping[i+1:]
+	j := strings.LastIndex(host, ":")
+	prefix, published = host[:j+1], host[j+1:]
+	return prefix, published, target, published != ""
+}
+
+// parameterizeBind replaces the parent directory of an absolute bind source
+// with a variable named after the service and the directory, so /srv/data/web
+// mounted by web becomes ${WEB_DATA_DIR}/web. Sources directly under / are
+// replaced as a whole, relative ones are kept.
+func parameterizeBind(service, source string, variable func(base, value string) string) string {
+	if !path.IsAbs(source) || source == "/" {
+		return source
+	}
+	source = path.Clean(source)
+	dir := path.Dir(source)
+	if dir == "/" {
+		return variable(service+"_"+path.Base(source)+"_DIR", source)
+	}
+	return variable(service+"_"+path.Base(dir)+"_DIR", dir) + "/" + path.Base(source)
+}
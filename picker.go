@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"docker-autocompose/autocompose"
+	"github.com/moby/term"
+)
+
+// pickContainers shows the containers matching the filters as a terminal list
+// and returns the IDs of the ones selected with space and confirmed with enter.
+// Only the keyboard is used so it works over ssh. The list is drawn on stderr,
+// leaving stdout to the compose file.
+func pickContainers(ctx context.Context, cli autocompose.Client, opts Options) ([]string, error) {
+	inFd, inIsTerminal := term.GetFdInfo(os.Stdin)
+	_, outIsTerminal := term.GetFdInfo(os.Stderr)
+	if !inIsTerminal || !outIsTerminal {
+		return nil, withKind(errUsage, fmt.Errorf("--interactive needs a terminal, pass the container names as arguments instead"))
+	}
+
+	containers, err := listContainers(ctx, cli, opts, "all")
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, withKind(errNotFound, fmt.Errorf("No containers to choose from"))
+	}
+
+	rows := make([]string, len(containers))
+	nameWidth, imageWidth := 0, 0
+	for _, c := range containers {
+		nameWidth = max(nameWidth, len(containerName(c.Names)))
+		imageWidth = max(imageWidth, len(c.Image))
+	}
+	for i, c := range containers {
+		rows[i] = fmt.Sprintf("%-*s  %-*s  %s", nameWidth, containerName(c.Names), imageWidth, c.Image, c.Status)
+	}
+
+	state, err := term.MakeRaw(inFd)
+	if err != nil {
+		return nil, fmt.Errorf("Error switching the terminal to raw mode: %v", err)
+	}
+	defer term.RestoreTerminal(inFd, state)
+
+	selected := make([]bool, len(containers))
+	cursor := 0
+	draw := func(redraw bool) {
+		if redraw {
+			fmt.Fprintf(os.Stderr, "\x1b[%dA", len(rows)+1)
+		}
+		fmt.Fprint(os.Stderr, "\x1b[2K\rspace: select, enter: export, q: cancel\r\n")
+		for i, row := range rows {
+			pointer, mark := " ", " "
+			if i == cursor {
+				pointer = ">"
+			}
+			if selected[i] {
+				mark = "x"
+			}
+			fmt.Fprintf(os.Stderr, "\x1b[2K\r%s [%s] %s\r\n", pointer, mark, row)
+		}
+	}
+	draw(false)
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		key, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case ' ':
+			selected[cursor] = !selected[cursor]
+		case '\r', '\n':
+			var ids []string
+			for i, c := range containers {
+				if selected[i] {
+					ids = append(ids, c.ID)
+				}
+			}
+			if len(ids) == 0 {
+				// Enter without a selection exports the highlighted container
+				ids = append(ids, containers[cursor].ID)
+			}
+			return ids, nil
+		case 'q', 3, 4: // q, ctrl-c, ctrl-d
+			return nil, fmt.Errorf("Cancelled")
+		case 'k':
+			cursor = (cursor + len(rows) - 1) % len(rows)
+		case 'j':
+			cursor = (cursor + 1) % len(rows)
+		case 0x1b: // arrow keys arrive as ESC [ A / ESC [ B
+			if next, _ := reader.ReadByte(); next != '[' {
+				continue
+			}
+			switch arrow, _ := reader.ReadByte(); arrow {
+			case 'A':
+				cursor = (cursor + len(rows) - 1) % len(rows)
+			case 'B':
+				cursor = (cursor + 1) % len(rows)
+			}
+		}
+		draw(true)
+	}
+}
+
+func containerName(names []string) string {
+	trimmed := make([]string, len(names))
+	for i, name := range names {
+		trimmed[i] = strings.TrimPrefix(name, "/")
+	}
+	return strings.Join(trimmed, ", ")
+}
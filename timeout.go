@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"docker-autocompose/autocompose"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// timeoutClient gives up on API calls the daemon does not answer in time.
+type timeoutClient struct {
+	autocompose.Client
+	timeout time.Duration
+}
+
+// withTimeout runs call with a deadline of timeout, none when it is 0. An
+// error caused by the deadline, rather than by the caller's context, says so
+// and names the operation.
+func withTimeout[T any](ctx context.Context, timeout time.Duration, operation string, call func(context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return call(ctx)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	value, err := call(callCtx)
+	if err != nil && ctx.Err() == nil && callCtx.Err() == context.DeadlineExceeded {
+		err = withKind(errDaemon, fmt.Errorf("no answer from the daemon to %s within %s (see --timeout)", operation, timeout))
+	}
+	return value, err
+}
+
+func (c timeoutClient) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	return withTimeout(ctx, c.timeout, "inspect container "+containerID, func(ctx context.Context) (container.InspectResponse, error) {
+		return c.Client.ContainerInspect(ctx, containerID)
+	})
+}
+
+func (c timeoutClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	return withTimeout(ctx, c.timeout, "list containers", func(ctx context.Context) ([]container.Summary, error) {
+		return c.Client.ContainerList(ctx, options)
+	})
+}
+
+func (c timeoutClient) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	return withTimeout(ctx, c.timeout, "inspect image "+imageID, func(ctx context.Context) (image.InspectResponse, error) {
+		return c.Client.ImageInspect(ctx, imageID, inspectOpts...)
+	})
+}
+
+func (c timeoutClient) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	return withTimeout(ctx, c.timeout, "inspect volume "+volumeID, func(ctx context.Context) (volume.Volume, error) {
+		return c.Client.VolumeInspect(ctx, volumeID)
+	})
+}
+
+func (c timeoutClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	return withTimeout(ctx, c.timeout, "inspect network "+networkID, func(ctx context.Context) (network.Inspect, error) {
+		return c.Client.NetworkInspect(ctx, networkID, options)
+	})
+}
+
+func (c timeoutClient) DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	return withTimeout(ctx, c.timeout, "inspect registry image "+imageRef, func(ctx context.Context) (registry.DistributionInspect, error) {
+		return c.Client.DistributionInspect(ctx, imageRef, encodedRegistryAuth)
+	})
+}
+
+func (c timeoutClient) Info(ctx context.Context) (system.Info, error) {
+	return withTimeout(ctx, c.timeout, "get the daemon info", c.Client.Info)
+}
+
+func (c timeoutClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	return withTimeout(ctx, c.timeout, "get the daemon version", c.Client.ServerVersion)
+}
+
+func (c timeoutClient) ServiceInspectWithRaw(ctx context.Context, serviceID string, opts types.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	var raw []byte
+	service, err := withTimeout(ctx, c.timeout, "inspect service "+serviceID, func(ctx context.Context) (swarm.Service, error) {
+		service, data, err := c.Client.ServiceInspectWithRaw(ctx, serviceID, opts)
+		raw = data
+		return service, err
+	})
+	return service, raw, err
+}